@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -115,6 +116,18 @@ func authorization(authUrl string) middleware {
 	})
 }
 
+// adminOnly rejects requests from non-admin callers. It must be used after the
+// authorization middleware, so requests are always rejected if there's none.
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !isCallerAdmin(r) {
+			respondError(rw, http.StatusForbidden, errors.New("only admins can access this API"))
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
 func originalReqUri(r *http.Request) string {
 	proto := "http"
 	if r.TLS != nil {
@@ -21,8 +21,14 @@ func respondError(rw http.ResponseWriter, defaultStatus int, errs ...error) {
 		response.Errors = append(response.Errors, err.Error())
 		if errors.Is(err, health.ErrStreamNotFound) ||
 			errors.Is(err, health.ErrEventNotFound) ||
+			errors.Is(err, health.ErrSessionNotFound) ||
+			errors.Is(err, health.ErrConditionNotFound) ||
+			errors.Is(err, health.ErrTaskNotFound) ||
 			errors.Is(err, views.ErrAssetNotFound) {
 			status = http.StatusNotFound
+		} else if errors.Is(err, health.ErrTaskTrackingDisabled) ||
+			errors.Is(err, health.ErrOrchestratorStatsDisabled) {
+			status = http.StatusNotImplemented
 		}
 	}
 	respondJson(rw, status, response)
@@ -2,16 +2,20 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/golang/glog"
 	"github.com/livepeer/livepeer-data/health"
+	"github.com/livepeer/livepeer-data/health/reducers"
 	"github.com/livepeer/livepeer-data/metrics"
 	"github.com/livepeer/livepeer-data/pkg/data"
 	"github.com/livepeer/livepeer-data/pkg/jsse"
@@ -27,7 +31,16 @@ const (
 	ssePingDelay    = 20 * time.Second
 	sseBufferSize   = 128
 
+	defaultStreamsLimit = 100
+	maxStreamsLimit     = 1000
+
+	defaultOrchestratorsLimit = 100
+	maxOrchestratorsLimit     = 1000
+
 	streamIDParam   = "streamId"
+	sessionIDParam  = "sessionId"
+	conditionParam  = "type"
+	taskIDParam     = "taskId"
 	assetIDParam    = "assetId"
 	playbackIDParam = "playbackId"
 )
@@ -56,6 +69,9 @@ type APIHandlerOptions struct {
 	ServerName, APIRoot, AuthURL  string
 	RegionalHostFormat, OwnRegion string
 	Prometheus                    bool
+	// WebSocketOrigins are the cross-site origins allowed to subscribe to
+	// events over a WebSocket.
+	WebSocketOrigins []string
 }
 
 type apiHandler struct {
@@ -83,6 +99,9 @@ func NewHandler(serverCtx context.Context, opts APIHandlerOptions, healthcore *h
 		router.Use(handler.cors())
 
 		router.Mount(`/stream/{`+streamIDParam+`}`, handler.streamHealthHandler())
+		router.Mount("/streams", handler.streamsHandler())
+		router.Mount("/orchestrators", handler.orchestratorsHandler())
+		router.Mount(`/task/{`+taskIDParam+`}`, handler.taskHandler())
 		router.Mount("/views", handler.viewershipHandler())
 		router.Mount("/usage", handler.usageHandler())
 	})
@@ -104,15 +123,94 @@ func (h *apiHandler) streamHealthHandler() chi.Router {
 
 	h.withMetrics(router, "get_stream_health").
 		MethodFunc("GET", "/health", h.getStreamHealth)
+	h.withMetrics(router, "stream_health_status").
+		MethodFunc("GET", "/health/stream", h.streamHealthStatus)
 	h.withMetrics(router, "stream_health_events").
 		MethodFunc("GET", "/events", h.subscribeEvents)
+	h.withMetrics(router, "get_stream_sessions").
+		MethodFunc("GET", "/sessions", h.getStreamSessions)
+	h.withMetrics(router, "get_session_health").
+		MethodFunc("GET", fmt.Sprintf("/sessions/{%s}/health", sessionIDParam), h.getSessionHealth)
+	h.withMetrics(router, "get_condition_history").
+		MethodFunc("GET", fmt.Sprintf("/conditions/{%s}/history", conditionParam), h.getConditionHistory)
+
+	return router
+}
+
+// streamsHandler serves the admin APIs across all the streams in the health core
+func (h *apiHandler) streamsHandler() chi.Router {
+	opts := h.opts
+
+	router := chi.NewRouter()
+	if h.core == nil {
+		router.Handle("/*", notImplementedErr(errors.New("stream health is disabled")))
+		return router
+	}
+	if opts.AuthURL != "" {
+		router.Use(authorization(opts.AuthURL))
+	}
+	router.Use(adminOnly)
+
+	h.withMetrics(router, "list_streams").
+		MethodFunc("GET", "/", h.listStreams)
+	h.withMetrics(router, "firehose_events").
+		MethodFunc("GET", "/events", h.subscribeFirehose)
+
+	return router
+}
+
+// orchestratorsHandler serves the admin APIs about the performance of the
+// orchestrators across all streams
+func (h *apiHandler) orchestratorsHandler() chi.Router {
+	opts := h.opts
+
+	router := chi.NewRouter()
+	if h.core == nil {
+		router.Handle("/*", notImplementedErr(errors.New("stream health is disabled")))
+		return router
+	}
+	if opts.AuthURL != "" {
+		router.Use(authorization(opts.AuthURL))
+	}
+	router.Use(adminOnly)
+
+	h.withMetrics(router, "list_orchestrators").
+		MethodFunc("GET", "/", h.listOrchestrators)
+
+	return router
+}
+
+// taskHandler serves the lifecycle status of the tasks. {taskId} variable must
+// be set in the request context. Task events have no owner to authorize the
+// caller against, so only admins can access them.
+func (h *apiHandler) taskHandler() chi.Router {
+	opts := h.opts
+
+	router := chi.NewRouter()
+	if h.core == nil {
+		router.Handle("/*", notImplementedErr(errors.New("stream health is disabled")))
+		return router
+	}
+	if opts.AuthURL != "" {
+		router.Use(authorization(opts.AuthURL))
+	}
+	router.Use(adminOnly)
+
+	h.withMetrics(router, "get_task_status").
+		MethodFunc("GET", "/status", h.getTaskStatus)
+	h.withMetrics(router, "stream_task_status").
+		MethodFunc("GET", "/status/stream", h.streamTaskStatus)
 
 	return router
 }
 
 func notImplemented() http.Handler {
+	return notImplementedErr(errors.New("bigquery is unavailable"))
+}
+
+func notImplementedErr(err error) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		respondError(rw, http.StatusNotImplemented, errors.New("bigquery is unavailable"))
+		respondError(rw, http.StatusNotImplemented, err)
 	})
 }
 
@@ -589,18 +687,376 @@ func (h *apiHandler) getStreamHealth(rw http.ResponseWriter, r *http.Request) {
 	respondJson(rw, http.StatusOK, getStreamStatus(r))
 }
 
+// streamHealthStatus sends the full health status of the stream, then a JSON
+// merge patch (RFC 7396) to it whenever the status changes.
+func (h *apiHandler) streamHealthStatus(rw http.ResponseWriter, r *http.Request) {
+	var (
+		streamID = getStreamStatus(r).ID
+		sseOpts  = jsse.InitOptions(r).
+				WithClientRetryBackoff(sseRetryBackoff).
+				WithPing(ssePingDelay).
+				WithAllowedOrigins(h.opts.WebSocketOrigins)
+	)
+	ctx, cancel := unionCtx(r.Context(), h.serverCtx)
+	defer cancel()
+	status, subscription, err := h.core.SubscribeStatus(ctx, streamID)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	sseEvents := h.makeHealthStatusSSEChan(ctx, status, subscription)
+	err = jsse.ServeEvents(ctx, sseOpts, rw, sseEvents)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if httpErr, ok := err.(jsse.HTTPError); ok {
+			status, err = httpErr.StatusCode, httpErr.Cause
+		}
+		glog.Errorf("Error serving health status events. err=%q", err)
+		respondError(rw, status, err)
+	}
+}
+
+// makeHealthStatusSSEChan sends the initial status as a health_status event,
+// then the diff from the last one as a health_patch event on every change.
+func (h *apiHandler) makeHealthStatusSSEChan(ctx context.Context, status *data.HealthStatus, subscription <-chan *data.HealthStatus) <-chan jsse.Event {
+	events := make(chan jsse.Event, sseBufferSize)
+	send := func(evt jsse.Event) bool {
+		select {
+		case events <- evt:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	go func() {
+		defer close(events)
+		full, err := json.Marshal(status)
+		if err != nil {
+			glog.Errorf("Error marshalling health status. streamID=%q, err=%q", status.ID, err)
+			return
+		}
+		if !send(jsse.Event{Event: "health_status", Data: full}) {
+			return
+		}
+		for newStatus := range subscription {
+			if newStatus == status {
+				continue
+			}
+			patch, err := data.DiffHealthStatus(status, newStatus)
+			if err != nil {
+				glog.Errorf("Error diffing health status. streamID=%q, err=%q", status.ID, err)
+				continue
+			}
+			status = newStatus
+			if patch != nil && !send(jsse.Event{Event: "health_patch", Data: patch}) {
+				return
+			}
+		}
+	}()
+	return events
+}
+
+func (h *apiHandler) getTaskStatus(rw http.ResponseWriter, r *http.Request) {
+	status, err := h.core.GetTaskStatus(apiParam(r, taskIDParam))
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	respondJson(rw, http.StatusOK, status)
+}
+
+// streamTaskStatus sends the status of the task as a task_status event every
+// time it changes, ending once the task is finished.
+func (h *apiHandler) streamTaskStatus(rw http.ResponseWriter, r *http.Request) {
+	sseOpts := jsse.InitOptions(r).
+		WithClientRetryBackoff(sseRetryBackoff).
+		WithPing(ssePingDelay).
+		WithAllowedOrigins(h.opts.WebSocketOrigins)
+
+	ctx, cancel := unionCtx(r.Context(), h.serverCtx)
+	defer cancel()
+	status, subscription, err := h.core.SubscribeTask(ctx, apiParam(r, taskIDParam))
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	events := make(chan jsse.Event, sseBufferSize)
+	go func() {
+		defer close(events)
+		for {
+			raw, err := json.Marshal(status)
+			if err != nil {
+				glog.Errorf("Error marshalling task status. taskID=%q, err=%q", status.ID, err)
+				return
+			}
+			select {
+			case events <- jsse.Event{Event: "task_status", Data: raw}:
+			case <-ctx.Done():
+				return
+			}
+			var ok bool
+			if status.Finished() {
+				return
+			} else if status, ok = <-subscription; !ok {
+				return
+			}
+		}
+	}()
+	err = jsse.ServeEvents(ctx, sseOpts, rw, events)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if httpErr, ok := err.(jsse.HTTPError); ok {
+			status, err = httpErr.StatusCode, httpErr.Cause
+		}
+		glog.Errorf("Error serving task status events. err=%q", err)
+		respondError(rw, status, err)
+	}
+}
+
+type streamSession struct {
+	ID        string              `json:"id"`
+	StartTime data.UnixMillisTime `json:"startTime"`
+	Healthy   *data.Condition     `json:"healthy"`
+}
+
+func (h *apiHandler) getStreamSessions(rw http.ResponseWriter, r *http.Request) {
+	streamID := getStreamStatus(r).ID
+	sessions, err := h.core.GetSessions(streamID)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	response := make([]streamSession, len(sessions))
+	for i, session := range sessions {
+		response[i] = streamSession{
+			ID:        session.ID,
+			StartTime: data.UnixMillisTime{Time: session.StartTime},
+			Healthy:   session.LastStatus.Healthy,
+		}
+	}
+	respondJson(rw, http.StatusOK, response)
+}
+
+func (h *apiHandler) getSessionHealth(rw http.ResponseWriter, r *http.Request) {
+	streamID := getStreamStatus(r).ID
+	status, err := h.core.GetSessionStatus(streamID, apiParam(r, sessionIDParam))
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	respondJson(rw, http.StatusOK, status)
+}
+
+type streamListItem struct {
+	ID         string            `json:"id"`
+	SessionID  string            `json:"sessionId,omitempty"`
+	NodeID     string            `json:"nodeId,omitempty"`
+	Region     string            `json:"region,omitempty"`
+	Healthy    *data.Condition   `json:"healthy"`
+	Conditions []*data.Condition `json:"conditions"`
+}
+
+type streamList struct {
+	Streams []streamListItem `json:"streams"`
+	Total   int              `json:"total"`
+}
+
+func (h *apiHandler) listStreams(rw http.ResponseWriter, r *http.Request) {
+	opts, errs := parseListStreamsOptions(r.URL.Query())
+	if len(errs) > 0 {
+		respondError(rw, http.StatusBadRequest, errs...)
+		return
+	}
+
+	streams, total := h.core.ListStreams(opts)
+	response := streamList{Streams: make([]streamListItem, len(streams)), Total: total}
+	for i, stream := range streams {
+		response.Streams[i] = streamListItem{
+			ID:         stream.ID,
+			SessionID:  stream.Status.SessionID,
+			NodeID:     stream.NodeID,
+			Region:     stream.Region,
+			Healthy:    stream.Status.Healthy,
+			Conditions: stream.Status.Conditions,
+		}
+	}
+	respondJson(rw, http.StatusOK, response)
+}
+
+func parseListStreamsOptions(qs url.Values) (health.ListStreamsOptions, []error) {
+	var (
+		opts = health.ListStreamsOptions{
+			NodeID:     qs.Get("nodeId"),
+			Region:     qs.Get("region"),
+			Conditions: map[data.ConditionType]bool{},
+			Limit:      defaultStreamsLimit,
+		}
+		errs []error
+	)
+	parseBool := func(name string, dest func(bool)) {
+		if str := qs.Get(name); str != "" {
+			val, err := strconv.ParseBool(str)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("bad %s %q: %w", name, str, err))
+				return
+			}
+			dest(val)
+		}
+	}
+	parseBool("healthy", func(val bool) { opts.Healthy = &val })
+	parseBool("active", func(val bool) { opts.Conditions[reducers.ConditionActive] = val })
+	for _, cond := range qs["condition"] {
+		condType, statusStr, _ := strings.Cut(cond, ":")
+		status, err := strconv.ParseBool(statusStr)
+		if condType == "" || err != nil {
+			errs = append(errs, fmt.Errorf("bad condition %q: must be in the format <type>:<true|false>", cond))
+			continue
+		}
+		opts.Conditions[data.ConditionType(condType)] = status
+	}
+	if err := parseIntQuery(qs, "offset", &opts.Offset, 0); err != nil {
+		errs = append(errs, err)
+	}
+	if err := parseIntQuery(qs, "limit", &opts.Limit, maxStreamsLimit); err != nil {
+		errs = append(errs, err)
+	}
+	if err := parseOrderQuery(qs, &opts.Ascending); err != nil {
+		errs = append(errs, err)
+	}
+	return opts, errs
+}
+
+func (h *apiHandler) listOrchestrators(rw http.ResponseWriter, r *http.Request) {
+	opts, errs := parseListOrchestratorsOptions(r.URL.Query(), h.core.OrchestratorStatsWindows())
+	if len(errs) > 0 {
+		respondError(rw, http.StatusBadRequest, errs...)
+		return
+	}
+
+	orchestrators, err := h.core.ListOrchestrators(opts)
+	if err != nil {
+		respondError(rw, http.StatusBadRequest, err)
+		return
+	}
+	respondJson(rw, http.StatusOK, orchestrators)
+}
+
+func parseListOrchestratorsOptions(qs url.Values, windows []time.Duration) (health.OrchestratorsOptions, []error) {
+	var (
+		opts = health.OrchestratorsOptions{Limit: defaultOrchestratorsLimit}
+		errs []error
+		err  error
+	)
+	if len(windows) > 0 {
+		opts.Window = windows[0]
+	}
+	if str := qs.Get("window"); str != "" {
+		if opts.Window, err = time.ParseDuration(str); err != nil {
+			errs = append(errs, fmt.Errorf("bad window %q: %w", str, err))
+		}
+	}
+	if str := qs.Get("sort"); str != "" {
+		if opts.SortBy, err = health.ParseOrchestratorSortKey(str); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := parseIntQuery(qs, "minAttempts", &opts.MinAttempts, 0); err != nil {
+		errs = append(errs, err)
+	}
+	if err := parseIntQuery(qs, "limit", &opts.Limit, maxOrchestratorsLimit); err != nil {
+		errs = append(errs, err)
+	}
+	if err := parseOrderQuery(qs, &opts.Ascending); err != nil {
+		errs = append(errs, err)
+	}
+	return opts, errs
+}
+
+// parseIntQuery parses the non-negative integer query param with the given
+// name into dest, leaving it unchanged if the param is missing. A max of 0
+// means no upper bound.
+func parseIntQuery(qs url.Values, name string, dest *int, max int) error {
+	str := qs.Get(name)
+	if str == "" {
+		return nil
+	}
+	val, err := strconv.Atoi(str)
+	if err != nil || val < 0 || (max > 0 && val > max) {
+		if max > 0 {
+			return fmt.Errorf("bad %s %q: must be an integer between 0 and %d", name, str, max)
+		}
+		return fmt.Errorf("bad %s %q: must be a non-negative integer", name, str)
+	}
+	*dest = val
+	return nil
+}
+
+// parseOrderQuery parses the order query param, which defaults to descending.
+func parseOrderQuery(qs url.Values, ascending *bool) error {
+	switch order := qs.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		*ascending = true
+	default:
+		return fmt.Errorf("bad order %q: must be asc or desc", order)
+	}
+	return nil
+}
+
+type conditionTransition struct {
+	Timestamp      data.UnixMillisTime `json:"timestamp"`
+	SessionID      string              `json:"sessionId,omitempty"`
+	PreviousStatus *bool               `json:"previousStatus"`
+	Status         *bool               `json:"status"`
+}
+
+func (h *apiHandler) getConditionHistory(rw http.ResponseWriter, r *http.Request) {
+	var (
+		streamID = getStreamStatus(r).ID
+		condType = data.ConditionType(apiParam(r, conditionParam))
+
+		from, err1 = parseInputTimestamp(r.URL.Query().Get("from"))
+		to, err2   = parseInputTimestamp(r.URL.Query().Get("to"))
+	)
+	if errs := nonNilErrs(err1, err2); len(errs) > 0 {
+		respondError(rw, http.StatusBadRequest, errs...)
+		return
+	}
+
+	history, err := h.core.GetConditionHistory(streamID, condType, from, to)
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	response := make([]conditionTransition, len(history))
+	for i, transition := range history {
+		response[i] = conditionTransition{
+			Timestamp:      data.UnixMillisTime{Time: transition.Time},
+			SessionID:      transition.SessionID,
+			PreviousStatus: transition.PreviousStatus,
+			Status:         transition.Status,
+		}
+	}
+	respondJson(rw, http.StatusOK, response)
+}
+
 func (h *apiHandler) subscribeEvents(rw http.ResponseWriter, r *http.Request) {
 	var (
 		streamStatus = getStreamStatus(r)
 		streamID     = streamStatus.ID
 		sseOpts      = jsse.InitOptions(r).
 				WithClientRetryBackoff(sseRetryBackoff).
-				WithPing(ssePingDelay)
+				WithPing(ssePingDelay).
+				WithAllowedOrigins(h.opts.WebSocketOrigins)
 
+		filter           = parseEventFilter(r.URL.Query())
 		lastEventID, err = parseInputUUID(sseOpts.LastEventID)
 		from, err1       = parseInputTimestamp(r.URL.Query().Get("from"))
 		to, err2         = parseInputTimestamp(r.URL.Query().Get("to"))
 		mustFindLast, _  = strconv.ParseBool(r.URL.Query().Get("mustFindLast"))
+		typedEvents, _   = strconv.ParseBool(r.URL.Query().Get("typedEvents"))
 	)
 	if errs := nonNilErrs(err, err1, err2); len(errs) > 0 {
 		respondError(rw, http.StatusBadRequest, errs...)
@@ -618,11 +1074,11 @@ func (h *apiHandler) subscribeEvents(rw http.ResponseWriter, r *http.Request) {
 			respondError(rw, http.StatusBadRequest, errors.New("query 'from' is required when using 'to'"))
 			return
 		}
-		pastEvents, err = h.core.GetPastEvents(streamID, from, to)
+		pastEvents, err = h.core.GetPastEvents(streamID, filter, from, to)
 	} else {
-		pastEvents, subscription, err = h.core.SubscribeEvents(ctx, streamID, lastEventID, from)
+		pastEvents, subscription, err = h.core.SubscribeEvents(ctx, streamID, filter, lastEventID, from)
 		if err == health.ErrEventNotFound && !mustFindLast {
-			pastEvents, subscription, err = h.core.SubscribeEvents(ctx, streamID, nil, nil)
+			pastEvents, subscription, err = h.core.SubscribeEvents(ctx, streamID, filter, nil, nil)
 		}
 	}
 	if err != nil {
@@ -630,7 +1086,7 @@ func (h *apiHandler) subscribeEvents(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sseEvents := makeSSEEventChan(ctx, pastEvents, subscription)
+	sseEvents := makeSSEEventChan(ctx, pastEvents, subscription, typedEvents)
 	err = jsse.ServeEvents(ctx, sseOpts, rw, sseEvents)
 	if err != nil {
 		status := http.StatusInternalServerError
@@ -642,11 +1098,73 @@ func (h *apiHandler) subscribeEvents(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func makeSSEEventChan(ctx context.Context, pastEvents []data.Event, subscription <-chan data.Event) <-chan jsse.Event {
+// parseEventFilter parses the filters for the events subscription APIs. Event
+// types can be specified with repeated types[] params. Independently of the
+// filter, the typedEvents param makes the SSE type of the events their actual
+// type instead of lp_event.
+func parseEventFilter(qs url.Values) health.EventFilter {
+	filter := health.EventFilter{
+		NodeID: qs.Get("nodeId"),
+		Region: qs.Get("region"),
+	}
+	for _, evtType := range qs["types[]"] {
+		filter.EventTypes = append(filter.EventTypes, data.EventType(evtType))
+	}
+	return filter
+}
+
+// subscribeFirehose streams the events of all streams, optionally filtered like
+// subscribeEvents and by a stream ID prefix. It supports resuming from a past
+// event like subscribeEvents, but only within the limited buffer of the
+// firehose.
+func (h *apiHandler) subscribeFirehose(rw http.ResponseWriter, r *http.Request) {
+	var (
+		query   = r.URL.Query()
+		sseOpts = jsse.InitOptions(r).
+			WithClientRetryBackoff(sseRetryBackoff).
+			WithPing(ssePingDelay).
+			WithAllowedOrigins(h.opts.WebSocketOrigins)
+		filter = parseEventFilter(query)
+
+		lastEventID, err = parseInputUUID(sseOpts.LastEventID)
+		from, err1       = parseInputTimestamp(query.Get("from"))
+		mustFindLast, _  = strconv.ParseBool(query.Get("mustFindLast"))
+		typedEvents, _   = strconv.ParseBool(query.Get("typedEvents"))
+	)
+	if errs := nonNilErrs(err, err1); len(errs) > 0 {
+		respondError(rw, http.StatusBadRequest, errs...)
+		return
+	}
+	filter.StreamIDPrefix = query.Get("streamIdPrefix")
+
+	ctx, cancel := unionCtx(r.Context(), h.serverCtx)
+	defer cancel()
+	pastEvents, subscription, err := h.core.SubscribeFirehose(ctx, filter, lastEventID, from)
+	if err == health.ErrEventNotFound && !mustFindLast {
+		pastEvents, subscription, err = h.core.SubscribeFirehose(ctx, filter, nil, nil)
+	}
+	if err != nil {
+		respondError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	sseEvents := makeSSEEventChan(ctx, pastEvents, subscription, typedEvents)
+	err = jsse.ServeEvents(ctx, sseOpts, rw, sseEvents)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if httpErr, ok := err.(jsse.HTTPError); ok {
+			status, err = httpErr.StatusCode, httpErr.Cause
+		}
+		glog.Errorf("Error serving firehose events. err=%q", err)
+		respondError(rw, status, err)
+	}
+}
+
+func makeSSEEventChan(ctx context.Context, pastEvents []data.Event, subscription <-chan data.Event, typed bool) <-chan jsse.Event {
 	if subscription == nil {
 		events := make(chan jsse.Event, len(pastEvents))
 		for _, evt := range pastEvents {
-			sendEvent(ctx, events, evt)
+			sendEvent(ctx, events, evt, typed)
 		}
 		close(events)
 		return events
@@ -655,12 +1173,12 @@ func makeSSEEventChan(ctx context.Context, pastEvents []data.Event, subscription
 	go func() {
 		defer close(events)
 		for _, evt := range pastEvents {
-			if !sendEvent(ctx, events, evt) {
+			if !sendEvent(ctx, events, evt, typed) {
 				return
 			}
 		}
 		for evt := range subscription {
-			if !sendEvent(ctx, events, evt) {
+			if !sendEvent(ctx, events, evt, typed) {
 				return
 			}
 		}
@@ -668,8 +1186,8 @@ func makeSSEEventChan(ctx context.Context, pastEvents []data.Event, subscription
 	return events
 }
 
-func sendEvent(ctx context.Context, dest chan<- jsse.Event, evt data.Event) bool {
-	sseEvt, err := toSSEEvent(evt)
+func sendEvent(ctx context.Context, dest chan<- jsse.Event, evt data.Event, typed bool) bool {
+	sseEvt, err := toSSEEvent(evt, typed)
 	if err != nil {
 		glog.Errorf("Skipping bad event due to error converting to SSE. evtID=%q, streamID=%q, err=%q", evt.ID(), evt.StreamID(), err)
 		return true
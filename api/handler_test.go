@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/health"
+	"github.com/livepeer/livepeer-data/health/reducers"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeEventsWebSocketOrigin(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	evt := data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: true})
+	raw, err := json.Marshal(evt)
+	require.NoError(err)
+	eventsFile := filepath.Join(t.TempDir(), "events.jsonl")
+	require.NoError(os.WriteFile(eventsFile, append(raw, '\n'), 0644))
+
+	consumer := event.NewFileStreamConsumer(eventsFile)
+	reducer, err := reducers.FromConfig(reducers.DefaultConfig(), "", nil, "")
+	require.NoError(err)
+	healthcore, err := health.NewCoreWithConsumer(health.CoreOptions{StartTimeOffset: time.Hour}, consumer, reducer, nil)
+	require.NoError(err)
+	defer healthcore.Close()
+	require.NoError(healthcore.Start(ctx))
+	<-consumer.Done()
+	healthcore.Flush()
+
+	handler := NewHandler(ctx, APIHandlerOptions{
+		APIRoot:          "/data",
+		WebSocketOrigins: []string{"https://allowed.example"},
+	}, healthcore, nil, nil)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	handshake := func(origin string) *http.Response {
+		conn, err := net.Dial("tcp", host)
+		require.NoError(err)
+		t.Cleanup(func() { conn.Close() })
+		key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+		_, err = io.WriteString(conn, "GET /data/stream/stream-1/events HTTP/1.1\r\nHost: "+host+"\r\n"+
+			"Connection: Upgrade\r\nUpgrade: websocket\r\nOrigin: "+origin+"\r\n"+
+			"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+key+"\r\n\r\n")
+		require.NoError(err)
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(err)
+		return res
+	}
+
+	require.Equal(http.StatusForbidden, handshake("https://evil.example").StatusCode)
+	require.Equal(http.StatusSwitchingProtocols, handshake("https://allowed.example").StatusCode)
+	require.Equal(http.StatusSwitchingProtocols, handshake(server.URL).StatusCode)
+}
+
+func TestParseListOptions(t *testing.T) {
+	require := require.New(t)
+
+	streamOpts, errs := parseListStreamsOptions(url.Values{"offset": {"20"}, "limit": {"5"}, "order": {"asc"}})
+	require.Empty(errs)
+	require.Equal(20, streamOpts.Offset)
+	require.Equal(5, streamOpts.Limit)
+	require.True(streamOpts.Ascending)
+
+	_, errs = parseListStreamsOptions(url.Values{"offset": {"-1"}, "limit": {"100000"}, "order": {"up"}})
+	require.Len(errs, 3)
+	require.EqualError(errs[0], `bad offset "-1": must be a non-negative integer`)
+	require.ErrorContains(errs[1], `bad limit "100000": must be an integer between 0 and`)
+	require.EqualError(errs[2], `bad order "up": must be asc or desc`)
+
+	orchOpts, errs := parseListOrchestratorsOptions(url.Values{"minAttempts": {"3"}}, []time.Duration{time.Hour})
+	require.Empty(errs)
+	require.Equal(3, orchOpts.MinAttempts)
+	require.Equal(defaultOrchestratorsLimit, orchOpts.Limit)
+	require.Equal(time.Hour, orchOpts.Window)
+	require.False(orchOpts.Ascending)
+
+	_, errs = parseListOrchestratorsOptions(url.Values{"minAttempts": {"x"}, "order": {"up"}}, nil)
+	require.Len(errs, 2)
+	require.EqualError(errs[0], `bad minAttempts "x": must be a non-negative integer`)
+}
+
+func TestToSSEEventType(t *testing.T) {
+	require := require.New(t)
+	evt := data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: true})
+
+	sseEvt, err := toSSEEvent(evt, false)
+	require.NoError(err)
+	require.Equal("lp_event", sseEvt.Event)
+	require.Equal(evt.ID().String(), sseEvt.ID)
+
+	sseEvt, err = toSSEEvent(evt, true)
+	require.NoError(err)
+	require.Equal(string(data.EventTypeStreamState), sseEvt.Event)
+}
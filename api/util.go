@@ -12,14 +12,28 @@ import (
 	"github.com/livepeer/livepeer-data/pkg/jsse"
 )
 
-func toSSEEvent(evt data.Event) (jsse.Event, error) {
+// toSSEEvent converts the event to SSE. Events are sent with the generic
+// lp_event SSE type for compatibility with existing clients, unless typed is
+// set to send them with the type of the event instead.
+func toSSEEvent(evt data.Event, typed bool) (jsse.Event, error) {
+	id := evt.ID().String()
+	if gap, ok := evt.(*data.SubscriptionGapEvent); ok {
+		// gap events are not stored so they can't be resumed from. The gap stands
+		// for the dropped events instead, which can still be fetched from its
+		// lastEventId.
+		id = gap.LastDroppedEventID.String()
+	}
 	data, err := json.Marshal(evt)
 	if err != nil {
 		return jsse.Event{}, err
 	}
+	sseType := "lp_event"
+	if typed {
+		sseType = string(evt.Type())
+	}
 	return jsse.Event{
-		ID:    evt.ID().String(),
-		Event: "lp_event",
+		ID:    id,
+		Event: sseType,
 		Data:  data,
 	}, nil
 }
@@ -0,0 +1,168 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/livepeer/livepeer-data/api"
+	"github.com/livepeer/livepeer-data/health"
+	"github.com/livepeer/livepeer-data/health/reducers"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
+)
+
+type replayFlags struct {
+	files            []string
+	streamID         string
+	serve            bool
+	healthConfigPath string
+
+	serverOpts api.ServerOptions
+}
+
+func parseReplayFlags(args []string) replayFlags {
+	cli := replayFlags{}
+	fs := flag.NewFlagSet("analyzer replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fs.Output().Write([]byte("Usage: analyzer replay [flags] <events.jsonl>... (use - for stdin)\n\n" +
+			"Replays a file of JSON-lines stream health events through the health core\n" +
+			"and prints the resulting health status timeline of each stream.\n\nFlags:\n"))
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&cli.healthConfigPath, "health-config", "", "Path to a YAML or JSON file configuring the health reducers, required conditions and thresholds. Uses the default pipeline if empty")
+	fs.StringVar(&cli.streamID, "stream-id", "", "Only print the timeline of the stream with this ID")
+	fs.BoolVar(&cli.serve, "serve", false, "Serve the replayed health state on the stream health API instead of printing the timeline")
+	fs.StringVar(&cli.serverOpts.Host, "host", "localhost", "Hostname to bind to when serving")
+	fs.UintVar(&cli.serverOpts.Port, "port", 8080, "Port to listen on when serving")
+	fs.StringVar(&cli.serverOpts.APIRoot, "api-root", "/data", "Root path where to bind the API to when serving")
+	fs.DurationVar(&cli.serverOpts.ShutdownGracePeriod, "shutdown-grace-perod", 15*time.Second, "Grace period to wait for server shutdown before using the force")
+
+	flag.Set("logtostderr", "true")
+	glogVFlag := flag.Lookup("v")
+	verbosity := fs.Int("v", 0, "Log verbosity {0-10}")
+
+	fs.Parse(args)
+	flag.CommandLine.Parse(nil)
+	glogVFlag.Value.Set(strconv.Itoa(*verbosity))
+
+	cli.files = fs.Args()
+	if len(cli.files) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return cli
+}
+
+func runReplay(build BuildFlags, args []string) {
+	cli := parseReplayFlags(args)
+	cli.serverOpts.APIHandlerOptions.ServerName = "analyzer/" + build.Version
+
+	ctx := contextUntilSignal(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	reducer, err := healthReducer(cli.healthConfigPath, "", nil, "")
+	if err != nil {
+		glog.Fatalf("Error creating health reducer. err=%q", err)
+	}
+	consumer := event.NewFileStreamConsumer(cli.files...)
+	timeline := &timelineReducer{
+		Reducer:   reducer,
+		streamID:  cli.streamID,
+		timelines: map[string][]timelineEntry{},
+	}
+	healthcore, err := health.NewCoreWithConsumer(health.CoreOptions{
+		StartTimeOffset: reducers.DefaultStarTimeOffset(),
+		EventWorkers:    1,
+	}, consumer, timeline, nil)
+	if err != nil {
+		glog.Fatalf("Error creating healthcore err=%q", err)
+	}
+	defer healthcore.Close()
+
+	if err := healthcore.Start(ctx); err != nil {
+		glog.Fatalf("Error starting health core. err=%q", err)
+	}
+	select {
+	case <-consumer.Done():
+	case <-ctx.Done():
+		return
+	}
+	healthcore.Flush()
+	glog.Infof("Finished replaying events. files=%q", cli.files)
+
+	if !cli.serve {
+		if err := timeline.Print(json.NewEncoder(os.Stdout)); err != nil {
+			glog.Fatalf("Error printing health timeline. err=%q", err)
+		}
+		return
+	}
+
+	glog.Info("Starting server...")
+	err = api.ListenAndServe(ctx, cli.serverOpts, healthcore, nil, nil)
+	if err != nil {
+		glog.Fatalf("Error starting api server. err=%q", err)
+	}
+}
+
+type timelineEntry struct {
+	StreamID  string              `json:"streamId"`
+	EventID   uuid.UUID           `json:"eventId"`
+	EventType data.EventType      `json:"eventType"`
+	Timestamp data.UnixMillisTime `json:"timestamp"`
+	Status    *data.HealthStatus  `json:"status"`
+}
+
+// timelineReducer wraps a reducer to record the health status of the streams
+// resulting from every event.
+type timelineReducer struct {
+	health.Reducer
+	streamID string
+
+	mu        sync.Mutex
+	timelines map[string][]timelineEntry
+}
+
+func (r *timelineReducer) Reduce(current *data.HealthStatus, state interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
+	current, state = r.Reducer.Reduce(current, state, evt)
+	if streamID := evt.StreamID(); r.streamID == "" || r.streamID == streamID {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.timelines[streamID] = append(r.timelines[streamID], timelineEntry{
+			StreamID:  streamID,
+			EventID:   evt.ID(),
+			EventType: evt.Type(),
+			Timestamp: data.UnixMillisTime{Time: evt.Timestamp()},
+			Status:    current,
+		})
+	}
+	return current, state
+}
+
+// Print writes the timeline entries of each stream in order, with the streams
+// sorted by their IDs.
+func (r *timelineReducer) Print(enc *json.Encoder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	streamIDs := make([]string, 0, len(r.timelines))
+	for streamID := range r.timelines {
+		streamIDs = append(streamIDs, streamID)
+	}
+	sort.Strings(streamIDs)
+	for _, streamID := range streamIDs {
+		for _, entry := range r.timelines[streamID] {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ require (
 	github.com/go-chi/chi/v5 v5.0.8
 	github.com/golang/glog v1.1.1
 	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/ipfs/go-cid v0.4.1
 	github.com/livepeer/go-api-client v0.4.23
 	github.com/peterbourgon/ff v1.7.1
@@ -20,6 +21,7 @@ require (
 	github.com/victorspringer/http-cache v0.0.0-20221205073845-df6d061f29cb
 	golang.org/x/sync v0.6.0
 	google.golang.org/api v0.126.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -95,5 +97,4 @@ require (
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20230711160842-782d3b101e98 // indirect
 	google.golang.org/grpc v1.58.3 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
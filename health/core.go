@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"runtime/debug"
 	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
@@ -20,11 +21,15 @@ import (
 const (
 	eventSubscriptionBufSize = 10
 	processLogSampleRate     = 0.04
+	maxSessionsPerRecord     = 20
+	maxConditionHistory      = 100
 )
 
 var (
-	ErrStreamNotFound = errors.New("stream not found")
-	ErrEventNotFound  = errors.New("event not found")
+	ErrStreamNotFound    = errors.New("stream not found")
+	ErrEventNotFound     = errors.New("event not found")
+	ErrSessionNotFound   = errors.New("session not found")
+	ErrConditionNotFound = errors.New("condition not found")
 
 	eventsProcessedCount = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
 		Name: metrics.FQName("events_processed_total"),
@@ -46,6 +51,16 @@ var (
 		Name: metrics.FQName("record_storage_size"),
 		Help: "Gauge for the current count of streams stored in memory in the record storage",
 	})
+	recordStorageBytes = metrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Name: metrics.FQName("record_storage_estimated_bytes"),
+		Help: "Gauge for the estimated memory usage in bytes of the records in a bounded record storage",
+	})
+	recordStorageEvictions = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: metrics.FQName("record_storage_evictions_total"),
+		Help: "Count of records evicted from the record storage, partitioned by the eviction reason",
+	},
+		[]string{"reason"},
+	)
 )
 
 // Purposedly made of built-in types only to bind directly to cli flags.
@@ -66,6 +81,26 @@ type CoreOptions struct {
 	Streaming          StreamingOptions
 	StartTimeOffset    time.Duration
 	MemoryRecordsTtl   time.Duration
+	Persistence        PersistenceOptions
+	// EventWorkers is the number of goroutines to process events in parallel,
+	// partitioned by stream ID. Defaults to 1 if not positive.
+	EventWorkers int
+	// EventWorkerQueueSize is the size of the queue of events of each worker.
+	EventWorkerQueueSize int
+	// TransitionNotifier is notified of every status change of the streams, if
+	// set. It is not closed by the core.
+	TransitionNotifier *TransitionNotifier
+	// TaskTracker handles the task events instead of the stream records, if set.
+	TaskTracker *TaskTracker
+	// OrchestratorStats aggregates the transcode attempts of all streams by
+	// orchestrator, if set.
+	OrchestratorStats *OrchestratorStats
+	// TickInterval is how often to call the reducer with the current time on the
+	// live streams, if it implements TickReducer. Disabled if not positive.
+	TickInterval time.Duration
+	// SubscriptionOverflowPolicy is applied to event subscriptions that can't
+	// keep up with the events. Defaults to OverflowGap.
+	SubscriptionOverflowPolicy OverflowPolicy
 }
 
 type Core struct {
@@ -76,31 +111,85 @@ type Core struct {
 	reducer        Reducer
 	conditionTypes []data.ConditionType
 
-	storage     RecordStorage
-	lastEventTs time.Time
+	storage RecordStorage
+	workers *eventWorkers
+	// lastEventTs is the unix nanos timestamp of the last processed event.
+	lastEventTs atomic.Int64
+
+	// firehose receives every processed event, across all streams.
+	firehose *firehose
+
+	persistence *recordsPersistence
+	// lastOffset is the offset in the stream of the last processed message,
+	// or -1 if there's none. Only used for persistence.
+	lastOffset int64
 }
 
-func NewCore(opts CoreOptions, reducer Reducer) (*Core, error) {
+// NewCore creates a new health core consuming from the RabbitMQ stream in the
+// options. If storage is nil, an unbounded MapRecordStorage is used.
+func NewCore(opts CoreOptions, reducer Reducer, storage RecordStorage) (*Core, error) {
 	consumer, err := event.NewStreamConsumer(opts.StreamUri, opts.AMQPUri)
 	if err != nil {
 		return nil, err
 	}
+	return NewCoreWithConsumer(opts, consumer, reducer, storage)
+}
 
-	return &Core{
-		opts:     opts,
-		consumer: consumer,
-		reducer:  reducer,
-		storage:  RecordStorage{SizeGauge: recordStorageSize},
-	}, nil
+// NewCoreWithConsumer creates a new health core that consumes events from the
+// given consumer instead. The core takes ownership of the consumer, closing it
+// on errors or when the core is closed.
+func NewCoreWithConsumer(opts CoreOptions, consumer event.StreamConsumer, reducer Reducer, storage RecordStorage) (*Core, error) {
+	var err error
+	if storage == nil {
+		storage = NewMapRecordStorage()
+	}
+	var persistence *recordsPersistence
+	if dir := opts.Persistence.Dir; dir != "" {
+		persistence, err = openRecordsPersistence(dir)
+		if err != nil {
+			consumer.Close()
+			return nil, err
+		}
+	}
+
+	core := &Core{
+		opts:        opts,
+		consumer:    consumer,
+		reducer:     reducer,
+		storage:     storage,
+		firehose:    newFirehose(firehoseBufferSize, opts.SubscriptionOverflowPolicy),
+		persistence: persistence,
+		lastOffset:  -1,
+	}
+	core.workers = newEventWorkers(opts.EventWorkers, opts.EventWorkerQueueSize, core.processEvent)
+	return core, nil
 }
 
 func (c *Core) Close() error {
-	return c.consumer.Close()
+	err := c.consumer.Close()
+	c.workers.Close()
+	if c.persistence != nil {
+		if snapErr := c.snapshot(); snapErr != nil {
+			glog.Errorf("Error saving records snapshot on close. err=%q", snapErr)
+		}
+		if closeErr := c.persistence.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Flush blocks until all the events received so far have been processed. It
+// should only be called when the consumer is not delivering new messages, like
+// after an offline replay is done.
+func (c *Core) Flush() {
+	c.workers.WaitPending()
 }
 
 func (c *Core) IsHealthy() bool {
-	if tol := c.opts.Streaming.EventFlowSilenceTolerance; tol > 0 && time.Since(c.lastEventTs) > tol {
-		glog.Warningf("Health core is unhealthy. reason=noEvents lastEventTs=%s, tolerance=%s", c.lastEventTs, tol)
+	lastEventTs := time.Unix(0, c.lastEventTs.Load())
+	if tol := c.opts.Streaming.EventFlowSilenceTolerance; tol > 0 && time.Since(lastEventTs) > tol {
+		glog.Warningf("Health core is unhealthy. reason=noEvents lastEventTs=%s, tolerance=%s", lastEventTs, tol)
 		return false
 	}
 	return true
@@ -113,6 +202,12 @@ func (c *Core) Start(ctx context.Context) error {
 	c.started = true
 	c.conditionTypes = c.reducer.Conditions()
 
+	if c.persistence != nil {
+		if err := c.restore(); err != nil {
+			return fmt.Errorf("failed to restore persisted records: %w", err)
+		}
+	}
+
 	consumeOpts, err := c.consumeOptions()
 	if err != nil {
 		return fmt.Errorf("invalid rabbitmq options: %w", err)
@@ -124,72 +219,179 @@ func (c *Core) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to consume stream: %w", err)
 	}
 	if c.opts.MemoryRecordsTtl > 0 {
-		c.storage.StartCleanupLoop(ctx, c.opts.MemoryRecordsTtl)
+		StartCleanupLoop(ctx, c.storage, c.opts.MemoryRecordsTtl)
+	}
+	if tracker := c.opts.TaskTracker; tracker != nil {
+		tracker.StartCleanupLoop(ctx)
+	}
+	if c.persistence != nil && c.opts.Persistence.SnapshotInterval > 0 {
+		go c.snapshotLoop(ctx, c.opts.Persistence.SnapshotInterval)
+	}
+	if _, ok := c.reducer.(TickReducer); ok && c.opts.TickInterval > 0 {
+		go c.tickLoop(ctx, c.opts.TickInterval)
 	}
 	return nil
 }
 
+// HandleMessage dispatches the events in the message to the processing
+// workers. It blocks while the worker queues are full, applying backpressure to
+// the stream consumer.
 func (c *Core) HandleMessage(msg event.StreamMessage) {
+	events := make([]workerEvent, 0, len(msg.Data))
 	for _, rawEvt := range msg.Data {
 		evt, err := data.ParseEvent(rawEvt)
 		if err != nil {
 			glog.Errorf("Health core received malformed message. err=%q, data=%q", err, rawEvt)
 			continue
 		}
+		events = append(events, workerEvent{evt, rawEvt})
+	}
 
-		start := time.Now()
-		err = c.handleSingleEvent(evt)
-		if err != nil {
-			glog.Errorf("Health core failed to process event. err=%q, event=%q", err, rawEvt)
-			continue
+	if c.persistence != nil {
+		c.persistence.Lock()
+		defer c.persistence.Unlock()
+	}
+	if !c.workers.Dispatch(events) {
+		glog.Warningf("Health core dropping message received after shutdown. events=%d", len(events))
+		return
+	}
+
+	if c.persistence != nil {
+		if cons := msg.Consumer; cons != nil {
+			c.lastOffset = cons.GetOffset()
 		}
-		dur := time.Since(start)
-
-		eventsProcessedCount.WithLabelValues(string(evt.Type())).
-			Inc()
-		eventsProcessingDuration.WithLabelValues(string(evt.Type())).
-			Observe(dur.Seconds() * 1000)
-		if evtOffset := time.Since(evt.Timestamp()); evtOffset > 0 {
-			eventsTimeOffset.Observe(evtOffset.Seconds())
+		for _, item := range events {
+			if err := c.persistence.AppendLocked(c.lastOffset, item.raw); err != nil {
+				glog.Errorf("Health core failed to persist event. err=%q, event=%q", err, item.raw)
+			}
 		}
+		if err := c.persistence.FlushLocked(); err != nil {
+			glog.Errorf("Error flushing health records write-ahead log. err=%q", err)
+		}
+	}
+}
+
+func (c *Core) processEvent(evt data.Event, rawEvt []byte) {
+	if tick, ok := evt.(*reducerTick); ok {
+		c.handleTick(c.reducer.(TickReducer), tick, false)
+		return
+	}
+	start := time.Now()
+	err := c.handleSingleEvent(evt, false)
+	if err != nil {
+		glog.Errorf("Health core failed to process event. err=%q, event=%q", err, rawEvt)
+		return
+	}
+	dur := time.Since(start)
+
+	eventsProcessedCount.WithLabelValues(string(evt.Type())).
+		Inc()
+	eventsProcessingDuration.WithLabelValues(string(evt.Type())).
+		Observe(dur.Seconds() * 1000)
+	if evtOffset := time.Since(evt.Timestamp()); evtOffset > 0 {
+		eventsTimeOffset.Observe(evtOffset.Seconds())
 	}
 }
 
-func (c *Core) handleSingleEvent(evt data.Event) (err error) {
-	streamID, ts := evt.StreamID(), evt.Timestamp()
-	c.lastEventTs = ts
+// handleSingleEvent reduces the event into the record of its stream. When
+// replaying the write-ahead log the state is updated without notifying the
+// transitions or publishing the event, since that was already done when the
+// event was first processed.
+func (c *Core) handleSingleEvent(evt data.Event, replay bool) (err error) {
+	streamID, sessionID, ts := evt.StreamID(), data.EventSessionID(evt), evt.Timestamp()
+	c.lastEventTs.Store(ts.UnixNano())
+	if tracker := c.opts.TaskTracker; tracker != nil && tracker.HandleEvent(evt) {
+		if !replay {
+			c.firehose.publish(evt)
+		}
+		return nil
+	}
 	record := c.storage.GetOrCreate(streamID, c.conditionTypes)
+	if tracker, ok := c.storage.(UpdateTrackingStorage); ok {
+		defer tracker.UpdateDone(record)
+	}
 
 	record.RLock()
 	status, state := record.LastStatus, record.ReducerState
+	_, duplicate := record.EventsByID[evt.ID()]
+	// Events with no session ID are attributed to the current session, if any.
+	session, isNewSession := record.CurrentSessionLocked(), false
+	if sessionID != "" {
+		var found bool
+		if session, found = record.SessionLocked(sessionID); !found {
+			session, isNewSession = newSessionRecord(streamID, sessionID, ts, c.conditionTypes), true
+		}
+	}
+	if session != nil {
+		status, state = session.LastStatus, session.ReducerState
+	}
 	record.RUnlock()
+	if duplicate {
+		// Happens when re-consuming the stream from a restored offset.
+		glog.V(6).Infof("Health core skipping duplicate event. streamID=%s, eventID=%s", streamID, evt.ID())
+		return nil
+	}
+	if orchStats := c.opts.OrchestratorStats; orchStats != nil {
+		orchStats.HandleEvent(evt)
+	}
 
-	// Only 1 go-routine processing events at a time, so no need for locking here.
+	// Events of a stream are always processed by the same worker, so no need for
+	// locking here.
+	prevStatus := status
 	status, state, err = reduceRecv(c.reducer, status, state, evt)
 	if err != nil {
 		return err
 	}
+	if notifier := c.opts.TransitionNotifier; notifier != nil && !replay {
+		notifier.StatusChanged(prevStatus, status)
+	}
 
 	record.Lock()
 	defer record.Unlock()
+	prevLastStatus := record.LastStatus
+	if session == nil {
+		record.LastStatus, record.ReducerState = status, state
+	} else {
+		session.LastStatus, session.ReducerState = status, state
+		if isNewSession {
+			glog.Infof("Health core found new stream session. streamID=%s, sessionID=%s, ts=%s", streamID, sessionID, ts)
+			record.Sessions = insertSessionSortedCropped(record.Sessions, session, maxSessionsPerRecord)
+		}
+		if session == record.CurrentSessionLocked() {
+			record.LastStatus = status
+		}
+	}
+	forEachTransition(prevStatus, status, func(condType data.ConditionType, previous *bool, cond *data.Condition) {
+		record.AddTransitionLocked(condType, ConditionTransition{
+			Time:           cond.LastTransitionTime.Time,
+			SessionID:      status.SessionID,
+			PreviousStatus: previous,
+			Status:         cond.Status,
+		}, maxConditionHistory)
+	})
+	if nodeID, region := data.EventNode(evt); nodeID != "" {
+		record.NodeID = nodeID
+		if region != "" {
+			record.Region = region
+		}
+	}
 	var removed []data.Event
-	record.LastStatus, record.ReducerState = status, state
 	record.PastEvents, removed = insertEventSortedCropped(record.PastEvents, evt, c.opts.StartTimeOffset) // TODO: Rename StartTimeOffset to sth that makes sense here as well
 	record.EventsByID[evt.ID()] = evt
 	for _, remEvt := range removed {
 		delete(record.EventsByID, remEvt.ID())
 	}
 	if glog.V(4) && rand.Float32() < processLogSampleRate {
-		glog.Infof("Sampled: Health core processing event. streamID=%s, ts=%s, pastEventsLen=%d, removedPastEvents=%d, event=%+v status=%+v",
-			streamID, ts, len(record.PastEvents), len(removed), evt, status)
+		glog.Infof("Sampled: Health core processing event. streamID=%s, sessionID=%s, ts=%s, pastEventsLen=%d, removedPastEvents=%d, event=%+v status=%+v",
+			streamID, sessionID, ts, len(record.PastEvents), len(removed), evt, status)
 	}
 
-	for _, subs := range record.EventSubs {
-		select {
-		case subs <- evt:
-		default:
-			glog.Warningf("Buffer full for health event subscription, skipping message. streamId=%q, eventTs=%q", streamID, ts)
+	if !replay {
+		if record.LastStatus != prevLastStatus {
+			record.PublishStatusLocked()
 		}
+		record.PublishLocked(evt)
+		c.firehose.publish(evt)
 	}
 	return nil
 }
@@ -217,17 +419,179 @@ func (c *Core) GetStatus(manifestID string) (*data.HealthStatus, error) {
 	return record.LastStatus, nil
 }
 
-func (c *Core) GetPastEvents(manifestID string, from, to *time.Time) ([]data.Event, error) {
+// GetSessions returns a copy of the session records of the given stream, sorted
+// by their start time.
+func (c *Core) GetSessions(streamID string) ([]SessionRecord, error) {
+	record, ok := c.storage.Get(streamID)
+	if !ok {
+		return nil, ErrStreamNotFound
+	}
+	record.RLock()
+	defer record.RUnlock()
+	sessions := make([]SessionRecord, len(record.Sessions))
+	for i, session := range record.Sessions {
+		sessions[i] = *session
+	}
+	return sessions, nil
+}
+
+func (c *Core) GetSessionStatus(streamID, sessionID string) (*data.HealthStatus, error) {
+	record, ok := c.storage.Get(streamID)
+	if !ok {
+		return nil, ErrStreamNotFound
+	}
+	record.RLock()
+	defer record.RUnlock()
+	session, ok := record.SessionLocked(sessionID)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return session.LastStatus, nil
+}
+
+// GetConditionHistory returns the transitions of a condition of the stream
+// between from and to, both optional, sorted by time. The Healthy condition can
+// be queried as data.ConditionHealthy.
+func (c *Core) GetConditionHistory(streamID string, condType data.ConditionType, from, to *time.Time) ([]ConditionTransition, error) {
+	if !c.hasCondition(condType) {
+		return nil, ErrConditionNotFound
+	}
+	record, ok := c.storage.Get(streamID)
+	if !ok {
+		return nil, ErrStreamNotFound
+	}
+	record.RLock()
+	defer record.RUnlock()
+	history := record.ConditionHistory[condType]
+	fromIdx, toIdx := 0, len(history)
+	if from != nil {
+		fromIdx = sort.Search(len(history), func(i int) bool { return !history[i].Time.Before(*from) })
+	}
+	if to != nil {
+		toIdx = sort.Search(len(history), func(i int) bool { return history[i].Time.After(*to) })
+	}
+	if toIdx < fromIdx {
+		return nil, errors.New("from timestamp must be lower than to timestamp")
+	}
+	transitions := make([]ConditionTransition, toIdx-fromIdx)
+	copy(transitions, history[fromIdx:toIdx])
+	return transitions, nil
+}
+
+func (c *Core) hasCondition(condType data.ConditionType) bool {
+	if condType == data.ConditionHealthy {
+		return true
+	}
+	for _, cond := range c.conditionTypes {
+		if cond == condType {
+			return true
+		}
+	}
+	return false
+}
+
+// ListStreamsOptions filters and paginates the streams listed by ListStreams.
+// The zero value lists all streams, newest transitions first.
+type ListStreamsOptions struct {
+	Healthy *bool
+	// Conditions are the required statuses of conditions by their type.
+	Conditions     map[data.ConditionType]bool
+	NodeID, Region string
+
+	// Ascending sorts by the oldest last transition of the Healthy condition
+	// first, instead of the newest.
+	Ascending     bool
+	Offset, Limit int
+}
+
+// StreamSummary is the current health information of a stream.
+type StreamSummary struct {
+	ID             string
+	NodeID, Region string
+	Status         *data.HealthStatus
+}
+
+// ListStreams returns the page of the streams matching the options, sorted by
+// the last transition of their Healthy condition, plus the total count of
+// matching streams.
+func (c *Core) ListStreams(opts ListStreamsOptions) ([]StreamSummary, int) {
+	var matches []StreamSummary
+	c.storage.Range(func(record *Record) bool {
+		record.RLock()
+		summary := StreamSummary{record.ID, record.NodeID, record.Region, record.LastStatus}
+		record.RUnlock()
+		if opts.matches(summary) {
+			matches = append(matches, summary)
+		}
+		return true
+	})
+
+	sort.Slice(matches, func(i, j int) bool {
+		ti, tj := lastTransition(matches[i].Status.Healthy), lastTransition(matches[j].Status.Healthy)
+		if !ti.Equal(tj) {
+			return ti.Before(tj) == opts.Ascending
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	total, start := len(matches), opts.Offset
+	if start > total {
+		start = total
+	}
+	end := total
+	if opts.Limit > 0 && start+opts.Limit < total {
+		end = start + opts.Limit
+	}
+	return matches[start:end], total
+}
+
+func (opts ListStreamsOptions) matches(summary StreamSummary) bool {
+	if opts.NodeID != "" && opts.NodeID != summary.NodeID {
+		return false
+	} else if opts.Region != "" && opts.Region != summary.Region {
+		return false
+	}
+	status := summary.Status
+	if opts.Healthy != nil && !conditionIs(status.Healthy, *opts.Healthy) {
+		return false
+	}
+	for condType, expected := range opts.Conditions {
+		if !conditionIs(status.Condition(condType), expected) {
+			return false
+		}
+	}
+	return true
+}
+
+func conditionIs(cond *data.Condition, status bool) bool {
+	return cond != nil && cond.Status != nil && *cond.Status == status
+}
+
+func lastTransition(cond *data.Condition) time.Time {
+	if cond == nil || cond.LastTransitionTime == nil {
+		return time.Time{}
+	}
+	return cond.LastTransitionTime.Time
+}
+
+func (c *Core) GetPastEvents(manifestID string, filter EventFilter, from, to *time.Time) ([]data.Event, error) {
 	record, ok := c.storage.Get(manifestID)
 	if !ok {
 		return nil, ErrStreamNotFound
 	}
 	record.RLock()
 	defer record.RUnlock()
-	return getPastEventsLocked(record, nil, from, to)
+	pastEvents, err := getPastEventsLocked(record, nil, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return filterEvents(pastEvents, filter), nil
 }
 
-func (c *Core) SubscribeEvents(ctx context.Context, manifestID string, lastEvtID *uuid.UUID, from *time.Time) ([]data.Event, <-chan data.Event, error) {
+// SubscribeEvents subscribes to the events of a stream that match the filter.
+// If lastEvtID or from are specified, also returns the past events after them
+// that match the filter.
+func (c *Core) SubscribeEvents(ctx context.Context, manifestID string, filter EventFilter, lastEvtID *uuid.UUID, from *time.Time) ([]data.Event, <-chan data.Event, error) {
 	var err error
 	record, ok := c.storage.Get(manifestID)
 	if !ok {
@@ -242,9 +606,64 @@ func (c *Core) SubscribeEvents(ctx context.Context, manifestID string, lastEvtID
 		if err != nil {
 			return nil, nil, err
 		}
+		pastEvents = filterEvents(pastEvents, filter)
 	}
-	subs := record.SubscribeLocked(ctx, make(chan data.Event, eventSubscriptionBufSize))
-	return pastEvents, subs, nil
+	subs := record.SubscribeLocked(ctx, newEventSubscription(ctx, manifestID, filter, c.opts.SubscriptionOverflowPolicy, eventSubscriptionBufSize))
+	return pastEvents, subs.Events(), nil
+}
+
+// SubscribeStatus returns the current health status of the stream and a
+// channel that receives its new status every time it changes, be it from an
+// event or a reducer tick. Only the latest status is kept for slow subscribers.
+func (c *Core) SubscribeStatus(ctx context.Context, streamID string) (*data.HealthStatus, <-chan *data.HealthStatus, error) {
+	record, ok := c.storage.Get(streamID)
+	if !ok {
+		return nil, nil, ErrStreamNotFound
+	}
+	record.Lock()
+	defer record.Unlock()
+	return record.LastStatus, record.SubscribeStatusLocked(ctx), nil
+}
+
+func (c *Core) GetTaskStatus(taskID string) (*data.TaskStatus, error) {
+	if c.opts.TaskTracker == nil {
+		return nil, ErrTaskTrackingDisabled
+	}
+	return c.opts.TaskTracker.GetStatus(taskID)
+}
+
+// SubscribeTask returns the current status of a task and a channel that
+// receives its updates, see TaskTracker.Subscribe.
+func (c *Core) SubscribeTask(ctx context.Context, taskID string) (*data.TaskStatus, <-chan *data.TaskStatus, error) {
+	if c.opts.TaskTracker == nil {
+		return nil, nil, ErrTaskTrackingDisabled
+	}
+	return c.opts.TaskTracker.Subscribe(ctx, taskID)
+}
+
+// ListOrchestrators returns the stats of the orchestrators that transcoded any
+// stream within the windows, see OrchestratorStats.List.
+func (c *Core) ListOrchestrators(opts OrchestratorsOptions) ([]OrchestratorSummary, error) {
+	if c.opts.OrchestratorStats == nil {
+		return nil, ErrOrchestratorStatsDisabled
+	}
+	return c.opts.OrchestratorStats.List(opts)
+}
+
+// OrchestratorStatsWindows returns the windows of the orchestrator stats, or
+// nil if they are disabled.
+func (c *Core) OrchestratorStatsWindows() []time.Duration {
+	if c.opts.OrchestratorStats == nil {
+		return nil
+	}
+	return c.opts.OrchestratorStats.Windows()
+}
+
+// SubscribeFirehose subscribes to the events of all streams that match the
+// filter. Past events are returned with the same semantics as SubscribeEvents,
+// but only from a limited buffer of the most recent events across all streams.
+func (c *Core) SubscribeFirehose(ctx context.Context, filter EventFilter, lastEvtID *uuid.UUID, from *time.Time) ([]data.Event, <-chan data.Event, error) {
+	return c.firehose.subscribe(ctx, filter, lastEvtID, from)
 }
 
 func getPastEventsLocked(record *Record, lastEvtID *uuid.UUID, from, to *time.Time) ([]data.Event, error) {
@@ -303,14 +722,23 @@ func (c *Core) consumeOptions() (event.ConsumeOptions, error) {
 	}
 
 	bindings := c.reducer.Bindings()
+	if tracker := c.opts.TaskTracker; tracker != nil {
+		bindings = append(bindings, tracker.Bindings()...)
+	}
 	startTime := time.Now().Add(-c.opts.StartTimeOffset)
+	offset := event.TimestampOffset(startTime)
+	if c.lastOffset >= 0 {
+		// Re-consume the last message since it could have been partially
+		// processed. Duplicate events are skipped by the core.
+		offset = event.OffsetSpec.Offset(c.lastOffset)
+	}
 	return event.ConsumeOptions{
 		Stream: opts.Stream,
 		StreamOptions: &event.StreamOptions{
 			StreamOptions: *streamOpts,
 			Bindings:      bindings,
 		},
-		ConsumerOptions: event.NewConsumerOptions(opts.ConsumerName, event.TimestampOffset(startTime)),
+		ConsumerOptions: event.NewConsumerOptions(opts.ConsumerName, offset),
 		MemorizeOffset:  true,
 	}, nil
 }
@@ -333,9 +761,89 @@ func insertEventSortedCropped(events []data.Event, event data.Event, maxWindow t
 	return []data.Event{}, events
 }
 
+func insertSessionSortedCropped(sessions []*SessionRecord, session *SessionRecord, maxLen int) []*SessionRecord {
+	insertIdx := len(sessions)
+	for insertIdx > 0 && session.StartTime.Before(sessions[insertIdx-1].StartTime) {
+		insertIdx--
+	}
+	sessions = append(sessions, nil)
+	copy(sessions[insertIdx+1:], sessions[insertIdx:])
+	sessions[insertIdx] = session
+
+	if len(sessions) > maxLen {
+		sessions = sessions[len(sessions)-maxLen:]
+	}
+	return sessions
+}
+
 func insertEventAtIdx(slc []data.Event, idx int, val data.Event) []data.Event {
 	slc = append(slc, nil)
 	copy(slc[idx+1:], slc[idx:])
 	slc[idx] = val
 	return slc
 }
+
+func (c *Core) restore() error {
+	snapshot, entries, err := c.persistence.Restore()
+	if err != nil {
+		return err
+	}
+	for _, recSnap := range snapshot.Records {
+		c.storage.Store(recSnap.toRecord(c.conditionTypes))
+	}
+	c.lastOffset = snapshot.Offset
+
+	for _, entry := range entries {
+		if entry.Tick != nil {
+			c.replayTick(*entry.Tick)
+			c.lastOffset = entry.Offset
+			continue
+		}
+		evt, err := data.ParseEvent(entry.Event)
+		if err != nil {
+			glog.Errorf("Health core skipping malformed event from write-ahead log. err=%q, data=%q", err, entry.Event)
+			continue
+		}
+		if err := c.handleSingleEvent(evt, true); err != nil {
+			glog.Errorf("Health core failed to replay event from write-ahead log. err=%q, event=%q", err, entry.Event)
+		}
+		c.lastOffset = entry.Offset
+	}
+	glog.Infof("Restored health records. records=%d, replayedEvents=%d, offset=%d", len(snapshot.Records), len(entries), c.lastOffset)
+	return nil
+}
+
+func (c *Core) snapshotLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.snapshot(); err != nil {
+				glog.Errorf("Error saving health records snapshot. err=%q", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Core) snapshot() error {
+	c.persistence.Lock()
+	defer c.persistence.Unlock()
+	// no events are dispatched while we hold the lock, so just wait for the
+	// workers to process what's already in their queues.
+	c.workers.WaitPending()
+
+	start := time.Now()
+	var records []*Record
+	c.storage.Range(func(record *Record) bool {
+		records = append(records, record)
+		return true
+	})
+	if err := c.persistence.SnapshotLocked(c.lastOffset, records); err != nil {
+		return err
+	}
+	glog.Infof("Saved health records snapshot. records=%d, offset=%d, duration=%s", len(records), c.lastOffset, time.Since(start))
+	return nil
+}
@@ -0,0 +1,141 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoreKeepsStateBySession(t *testing.T) {
+	require := require.New(t)
+	core := newPersistedCore(t, t.TempDir())
+	defer core.persistence.Close()
+	defer core.workers.Close()
+
+	newEvt := func(sessionID string) data.Event {
+		evt := data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: true})
+		evt.SessionID = sessionID
+		return evt
+	}
+	handleEvents(t, core, newEvt("session-1"), newEvt("session-1"), newEvt("session-2"), newEvt(""))
+	core.workers.WaitPending()
+
+	sessions, err := core.GetSessions("stream-1")
+	require.NoError(err)
+	require.Len(sessions, 2)
+	require.Equal("session-1", sessions[0].ID)
+	require.Equal(2, sessions[0].ReducerState)
+	// events without a session are attributed to the current one
+	require.Equal("session-2", sessions[1].ID)
+	require.Equal(2, sessions[1].ReducerState)
+
+	status, err := core.GetSessionStatus("stream-1", "session-1")
+	require.NoError(err)
+	require.Equal("session-1", status.SessionID)
+	status, err = core.GetStatus("stream-1")
+	require.NoError(err)
+	require.Equal("session-2", status.SessionID)
+
+	_, err = core.GetSessionStatus("stream-1", "session-3")
+	require.ErrorIs(err, ErrSessionNotFound)
+}
+
+func TestCoreRecordsConditionHistory(t *testing.T) {
+	require := require.New(t)
+	const condActive data.ConditionType = "Active"
+	core := &Core{
+		reducer: ReducerFunc(func(current *data.HealthStatus, state interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
+			active := evt.(*data.StreamStateEvent).State.Active
+			conditions := current.ConditionsCopy()
+			conditions[0] = data.NewCondition(condActive, evt.Timestamp(), &active, current.Condition(condActive))
+			return data.NewMergedHealthStatus(current, data.HealthStatus{Conditions: conditions}), state
+		}),
+		storage:        NewMapRecordStorage(),
+		conditionTypes: []data.ConditionType{condActive},
+		opts:           CoreOptions{StartTimeOffset: time.Hour},
+	}
+
+	start := time.Now()
+	for i, active := range []bool{true, true, false, true} {
+		evt := data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: active})
+		evt.Timestamp_ = data.UnixMillisTime{Time: start.Add(time.Duration(i) * time.Second)}
+		require.NoError(core.handleSingleEvent(evt, false))
+	}
+
+	history, err := core.GetConditionHistory("stream-1", condActive, nil, nil)
+	require.NoError(err)
+	require.Len(history, 3)
+	require.Nil(history[0].PreviousStatus)
+	require.True(*history[0].Status)
+	require.True(*history[1].PreviousStatus)
+	require.False(*history[1].Status)
+	require.True(*history[2].Status)
+
+	from, to := start.Add(time.Second), start.Add(2*time.Second)
+	history, err = core.GetConditionHistory("stream-1", condActive, &from, &to)
+	require.NoError(err)
+	require.Len(history, 1)
+	require.False(*history[0].Status)
+
+	_, err = core.GetConditionHistory("stream-1", "Unknown", nil, nil)
+	require.ErrorIs(err, ErrConditionNotFound)
+}
+
+func TestCoreListStreams(t *testing.T) {
+	require := require.New(t)
+	core := &Core{
+		reducer: ReducerFunc(func(current *data.HealthStatus, state interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
+			active := evt.(*data.StreamStateEvent).State.Active
+			healthy := data.NewCondition("", evt.Timestamp(), &active, current.Healthy)
+			return data.NewMergedHealthStatus(current, data.HealthStatus{Healthy: healthy}), state
+		}),
+		storage: NewMapRecordStorage(),
+		opts:    CoreOptions{StartTimeOffset: time.Hour},
+	}
+
+	start := time.Now()
+	for i, stream := range []struct {
+		id, node, region string
+		active           bool
+	}{
+		{"stream-1", "node-1", "region-1", true},
+		{"stream-2", "node-2", "region-1", false},
+		{"stream-3", "node-1", "region-2", true},
+	} {
+		evt := data.NewStreamStateEvent(stream.node, stream.region, "user", stream.id, data.StreamState{Active: stream.active})
+		evt.Timestamp_ = data.UnixMillisTime{Time: start.Add(time.Duration(i) * time.Second)}
+		require.NoError(core.handleSingleEvent(evt, false))
+	}
+	ids := func(streams []StreamSummary) []string {
+		var ids []string
+		for _, s := range streams {
+			ids = append(ids, s.ID)
+		}
+		return ids
+	}
+
+	streams, total := core.ListStreams(ListStreamsOptions{})
+	require.Equal(3, total)
+	require.Equal([]string{"stream-3", "stream-2", "stream-1"}, ids(streams))
+	require.Equal("node-1", streams[0].NodeID)
+	require.Equal("region-2", streams[0].Region)
+
+	healthy := true
+	streams, total = core.ListStreams(ListStreamsOptions{Healthy: &healthy, Ascending: true})
+	require.Equal(2, total)
+	require.Equal([]string{"stream-1", "stream-3"}, ids(streams))
+
+	streams, total = core.ListStreams(ListStreamsOptions{Region: "region-1", NodeID: "node-2"})
+	require.Equal(1, total)
+	require.Equal([]string{"stream-2"}, ids(streams))
+
+	streams, total = core.ListStreams(ListStreamsOptions{Offset: 1, Limit: 1})
+	require.Equal(3, total)
+	require.Equal([]string{"stream-2"}, ids(streams))
+
+	streams, total = core.ListStreams(ListStreamsOptions{Offset: 5})
+	require.Equal(3, total)
+	require.Empty(streams)
+}
@@ -0,0 +1,104 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/livepeer/livepeer-data/pkg/data"
+)
+
+const (
+	// firehoseBufferSize is the number of recent events kept by the firehose for
+	// resuming subscriptions, across all streams.
+	firehoseBufferSize = 10000
+	// firehoseSubscriptionBufSize is larger than the one for a single stream
+	// since the firehose receives the events from all of them.
+	firehoseSubscriptionBufSize = 1000
+)
+
+// firehose broadcasts every event processed by the core to its subscribers,
+// keeping a buffer of the most recent events for resuming subscriptions.
+type firehose struct {
+	mu sync.Mutex
+	// past holds the events in processing order. Only the last bufferSize are
+	// valid, the slice is compacted lazily to avoid copying on every event.
+	past       []data.Event
+	bufferSize int
+	policy     OverflowPolicy
+	subs       []*EventSubscription
+}
+
+func newFirehose(bufferSize int, policy OverflowPolicy) *firehose {
+	return &firehose{bufferSize: bufferSize, policy: policy}
+}
+
+func (f *firehose) publish(evt data.Event) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.past = append(f.past, evt)
+	if len(f.past) >= 2*f.bufferSize {
+		f.past = append([]data.Event(nil), f.recentLocked()...)
+	}
+	for i := 0; i < len(f.subs); {
+		if f.subs[i].send(evt) {
+			i++
+			continue
+		}
+		f.subs = append(f.subs[:i], f.subs[i+1:]...)
+	}
+}
+
+func (f *firehose) recentLocked() []data.Event {
+	if len(f.past) <= f.bufferSize {
+		return f.past
+	}
+	return f.past[len(f.past)-f.bufferSize:]
+}
+
+// subscribe returns the buffered events matching the filter that came after
+// lastEvtID or with a timestamp after from, if any of those are specified, and
+// a channel to receive the new events. The channel is closed when the context
+// is done.
+func (f *firehose) subscribe(ctx context.Context, filter EventFilter, lastEvtID *uuid.UUID, from *time.Time) ([]data.Event, <-chan data.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var pastEvents []data.Event
+	if lastEvtID != nil || from != nil {
+		recent, startIdx := f.recentLocked(), 0
+		if lastEvtID != nil {
+			startIdx = -1
+			for i, evt := range recent {
+				if evt.ID() == *lastEvtID {
+					startIdx = i + 1
+					break
+				}
+			}
+			if startIdx < 0 {
+				return nil, nil, ErrEventNotFound
+			}
+		}
+		for _, evt := range recent[startIdx:] {
+			if filter.Matches(evt) && (from == nil || evt.Timestamp().After(*from)) {
+				pastEvents = append(pastEvents, evt)
+			}
+		}
+	}
+
+	sub := newEventSubscription(ctx, "", filter, f.policy, firehoseSubscriptionBufSize)
+	f.subs = append(f.subs, sub)
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		sub.close()
+		f.subs = removeSubscription(f.subs, sub)
+	}()
+	return pastEvents, sub.Events(), nil
+}
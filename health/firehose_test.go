@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventFiltersAndResumes(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := newFirehose(3, OverflowGap)
+	start := time.Now()
+	newEvent := func(streamID string, i int) data.Event {
+		evt := data.NewStreamStateEvent("node", "region", "user", streamID, data.StreamState{Active: true})
+		evt.Timestamp_ = data.UnixMillisTime{Time: start.Add(time.Duration(i) * time.Second)}
+		return evt
+	}
+
+	filter := EventFilter{StreamIDPrefix: "video+"}
+	_, subs, err := f.subscribe(ctx, filter, nil, nil)
+	require.NoError(err)
+	_, otherSubs, err := f.subscribe(ctx, EventFilter{EventTypes: []data.EventType{"transcode"}}, nil, nil)
+	require.NoError(err)
+
+	var events []data.Event
+	for i, streamID := range []string{"video+1", "other", "video+2", "video+3", "video+4"} {
+		evt := newEvent(streamID, i)
+		events = append(events, evt)
+		f.publish(evt)
+	}
+	for _, idx := range []int{0, 2, 3, 4} {
+		require.Equal(events[idx], <-subs)
+	}
+	require.Len(subs, 0)
+	require.Len(otherSubs, 0)
+
+	// only the last 3 events are kept for resuming
+	lastID := events[2].ID()
+	past, _, err := f.subscribe(ctx, filter, &lastID, nil)
+	require.NoError(err)
+	require.Equal(events[3:], past)
+
+	lastID = events[0].ID()
+	_, _, err = f.subscribe(ctx, filter, &lastID, nil)
+	require.ErrorIs(err, ErrEventNotFound)
+
+	// events at exactly the from timestamp are excluded, like for a single stream
+	from := start.Add(3 * time.Second)
+	past, _, err = f.subscribe(ctx, filter, nil, &from)
+	require.NoError(err)
+	require.Equal(events[4:], past)
+
+	cancel()
+	_, ok := <-subs
+	require.False(ok)
+}
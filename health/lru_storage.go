@@ -0,0 +1,211 @@
+package health
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Rough estimates of the memory used by each record, to avoid the cost of
+// measuring the actual size of every event and status.
+const (
+	estimatedRecordBaseBytes = 4 * 1024
+	estimatedEventBytes      = 1024
+	estimatedSessionBytes    = 2 * 1024
+	estimatedTransitionBytes = 64
+)
+
+type LRUStorageOptions struct {
+	// MaxRecords is the maximum number of records to keep. Unbounded if 0.
+	MaxRecords int
+	// MaxBytes is the maximum estimated size in bytes of all records. Unbounded
+	// if 0.
+	MaxBytes int64
+}
+
+// LRURecordStorage is a RecordStorage bounded by record count or estimated
+// memory usage. When full, the least recently updated records are evicted. Only
+// GetOrCreate counts as an update since it is what the core calls for every
+// event, so API reads do not keep records of finished streams alive. Records
+// being updated are not evicted and their size is only estimated once done.
+type LRURecordStorage struct {
+	opts LRUStorageOptions
+
+	mu      sync.Mutex
+	order   *list.List // of *lruEntry, most recently updated at the front
+	entries map[string]*list.Element
+	bytes   int64
+
+	SizeGauge, BytesGauge prometheus.Gauge
+	EvictionsCount        prometheus.Counter
+}
+
+type lruEntry struct {
+	record *Record
+	bytes  int64
+	// updating is the count of GetOrCreate calls not followed by UpdateDone yet.
+	updating int
+}
+
+func NewLRURecordStorage(opts LRUStorageOptions) *LRURecordStorage {
+	return &LRURecordStorage{
+		opts:           opts,
+		order:          list.New(),
+		entries:        map[string]*list.Element{},
+		SizeGauge:      recordStorageSize,
+		BytesGauge:     recordStorageBytes,
+		EvictionsCount: recordStorageEvictions.WithLabelValues("capacity"),
+	}
+}
+
+func (s *LRURecordStorage) Get(id string) (*Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elm, ok := s.entries[id]; ok {
+		return elm.Value.(*lruEntry).record, true
+	}
+	return nil, false
+}
+
+func (s *LRURecordStorage) GetOrCreate(id string, conditions []data.ConditionType) *Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elm, ok := s.entries[id]; ok {
+		s.order.MoveToFront(elm)
+		entry := elm.Value.(*lruEntry)
+		entry.updating++
+		return entry.record
+	}
+
+	new := NewRecord(id, conditions)
+	s.pushLocked(new).updating++
+	glog.Infof("Created new health record. id=%q", id)
+	s.evictLocked()
+	return new
+}
+
+// UpdateDone re-estimates the size of the record after the core updated it,
+// evicting other records if the storage is full.
+func (s *LRURecordStorage) UpdateDone(record *Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elm, ok := s.entries[record.ID]
+	if !ok || elm.Value.(*lruEntry).record != record {
+		// deleted or replaced while updating
+		return
+	}
+	entry := elm.Value.(*lruEntry)
+	entry.updating--
+	s.resizeLocked(entry)
+	s.evictLocked()
+}
+
+func (s *LRURecordStorage) Store(record *Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elm, ok := s.entries[record.ID]; ok {
+		s.removeLocked(elm)
+	}
+	s.pushLocked(record)
+	s.evictLocked()
+}
+
+func (s *LRURecordStorage) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elm, ok := s.entries[id]; ok {
+		s.removeLocked(elm)
+	}
+}
+
+func (s *LRURecordStorage) Range(f func(record *Record) bool) {
+	s.mu.Lock()
+	records := make([]*Record, 0, s.order.Len())
+	for elm := s.order.Front(); elm != nil; elm = elm.Next() {
+		records = append(records, elm.Value.(*lruEntry).record)
+	}
+	s.mu.Unlock()
+
+	for _, record := range records {
+		if !f(record) {
+			return
+		}
+	}
+}
+
+func (s *LRURecordStorage) pushLocked(record *Record) *lruEntry {
+	entry := &lruEntry{record: record}
+	s.entries[record.ID] = s.order.PushFront(entry)
+	s.resizeLocked(entry)
+	s.updateGaugesLocked()
+	return entry
+}
+
+func (s *LRURecordStorage) removeLocked(elm *list.Element) {
+	entry := elm.Value.(*lruEntry)
+	s.order.Remove(elm)
+	delete(s.entries, entry.record.ID)
+	s.bytes -= entry.bytes
+	entry.record.Dispose()
+	s.updateGaugesLocked()
+}
+
+// evictLocked removes the least recently updated records until the storage is
+// within its bounds again. The most recent record and the ones being updated
+// are never evicted, so the storage may stay full until their updates are done.
+func (s *LRURecordStorage) evictLocked() {
+	for elm := s.order.Back(); elm != nil && elm != s.order.Front() && s.isFullLocked(); {
+		prev, entry := elm.Prev(), elm.Value.(*lruEntry)
+		if entry.updating == 0 {
+			glog.Infof("Evicting health record from full storage. id=%q, size=%d, bytes=%d", entry.record.ID, s.order.Len(), s.bytes)
+			s.removeLocked(elm)
+			if s.EvictionsCount != nil {
+				s.EvictionsCount.Inc()
+			}
+		}
+		elm = prev
+	}
+}
+
+func (s *LRURecordStorage) isFullLocked() bool {
+	return (s.opts.MaxRecords > 0 && s.order.Len() > s.opts.MaxRecords) ||
+		(s.opts.MaxBytes > 0 && s.bytes > s.opts.MaxBytes)
+}
+
+func (s *LRURecordStorage) resizeLocked(entry *lruEntry) {
+	newBytes := estimateRecordBytes(entry.record)
+	s.bytes += newBytes - entry.bytes
+	entry.bytes = newBytes
+	if s.BytesGauge != nil {
+		s.BytesGauge.Set(float64(s.bytes))
+	}
+}
+
+func (s *LRURecordStorage) updateGaugesLocked() {
+	if s.SizeGauge != nil {
+		s.SizeGauge.Set(float64(s.order.Len()))
+	}
+	if s.BytesGauge != nil {
+		s.BytesGauge.Set(float64(s.bytes))
+	}
+}
+
+func estimateRecordBytes(record *Record) int64 {
+	record.RLock()
+	defer record.RUnlock()
+	return estimatedRecordBaseBytes +
+		int64(len(record.PastEvents))*estimatedEventBytes +
+		int64(len(record.Sessions))*estimatedSessionBytes +
+		int64(countTransitions(record))*estimatedTransitionBytes
+}
+
+func countTransitions(record *Record) int {
+	count := 0
+	for _, history := range record.ConditionHistory {
+		count += len(history)
+	}
+	return count
+}
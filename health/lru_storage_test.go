@@ -0,0 +1,103 @@
+package health
+
+import (
+	"testing"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+// updateRecord simulates the core processing an event of the record.
+func updateRecord(storage *LRURecordStorage, id string) *Record {
+	record := storage.GetOrCreate(id, nil)
+	storage.UpdateDone(record)
+	return record
+}
+
+func TestLRUStorageEvictsLeastRecentlyUpdated(t *testing.T) {
+	require := require.New(t)
+	storage := NewLRURecordStorage(LRUStorageOptions{MaxRecords: 2})
+
+	first := updateRecord(storage, "1")
+	updateRecord(storage, "2")
+	// reads do not count as updates
+	_, ok := storage.Get("1")
+	require.True(ok)
+	updateRecord(storage, "3")
+
+	_, ok = storage.Get("1")
+	require.False(ok)
+	require.True(isDisposed(first))
+
+	updateRecord(storage, "2")
+	updateRecord(storage, "4")
+	_, ok = storage.Get("3")
+	require.False(ok)
+	_, ok = storage.Get("2")
+	require.True(ok)
+}
+
+func TestLRUStorageBoundedByBytes(t *testing.T) {
+	require := require.New(t)
+	storage := NewLRURecordStorage(LRUStorageOptions{MaxBytes: 2*estimatedRecordBaseBytes + estimatedEventBytes})
+
+	updateRecord(storage, "1")
+	updateRecord(storage, "2")
+	count := 0
+	storage.Range(func(*Record) bool { count++; return true })
+	require.Equal(2, count)
+
+	updateRecord(storage, "3")
+	_, ok := storage.Get("1")
+	require.False(ok)
+}
+
+func TestLRUStorageEstimatesSizeAfterUpdate(t *testing.T) {
+	require := require.New(t)
+	storage := NewLRURecordStorage(LRUStorageOptions{MaxBytes: 2*estimatedRecordBaseBytes + estimatedEventBytes})
+
+	updateRecord(storage, "1")
+	record := storage.GetOrCreate("2", nil)
+	record.Lock()
+	for i := 0; i < 2; i++ {
+		evt := data.NewStreamStateEvent("node", "region", "user", "2", data.StreamState{Active: true})
+		record.PastEvents = append(record.PastEvents, evt)
+		record.EventsByID[evt.ID()] = evt
+	}
+	record.Unlock()
+	// the events are accounted as soon as the update is done, not on the next one
+	storage.UpdateDone(record)
+	require.EqualValues(estimatedRecordBaseBytes+2*estimatedEventBytes, storage.bytes)
+	_, ok := storage.Get("1")
+	require.False(ok)
+}
+
+func TestLRUStorageSkipsRecordsBeingUpdated(t *testing.T) {
+	require := require.New(t)
+	storage := NewLRURecordStorage(LRUStorageOptions{MaxRecords: 2})
+
+	updating := storage.GetOrCreate("1", nil)
+	updateRecord(storage, "2")
+	updateRecord(storage, "3")
+	// the least recently updated record is still being updated, so the next one
+	// is evicted instead
+	_, ok := storage.Get("1")
+	require.True(ok)
+	_, ok = storage.Get("2")
+	require.False(ok)
+
+	storage.UpdateDone(updating)
+	updateRecord(storage, "4")
+	_, ok = storage.Get("1")
+	require.False(ok)
+	require.True(isDisposed(updating))
+}
+
+func isDisposed(record *Record) bool {
+	select {
+	case <-record.disposed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,262 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/stats"
+)
+
+// orchestratorStatsBuckets is the number of buckets of each window of the
+// orchestrator stats, so memory per orchestrator is the same for any window.
+const orchestratorStatsBuckets = 60
+
+var (
+	ErrOrchestratorStatsDisabled = errors.New("orchestrator stats are disabled")
+
+	orchestratorLatencyStats = []stats.Stat{stats.StatAverage, stats.PercentileStat(50), stats.PercentileStat(95), stats.PercentileStat(99)}
+)
+
+// OrchestratorSortKey is a field of the orchestrator window stats by which
+// the orchestrators can be sorted.
+type OrchestratorSortKey string
+
+const (
+	SortBySuccessRate OrchestratorSortKey = "successRate"
+	SortByAttempts    OrchestratorSortKey = "attempts"
+	SortByErrors      OrchestratorSortKey = "errors"
+	SortByLatencyP50  OrchestratorSortKey = "latencyP50"
+	SortByLatencyP95  OrchestratorSortKey = "latencyP95"
+	SortByLatencyP99  OrchestratorSortKey = "latencyP99"
+)
+
+func ParseOrchestratorSortKey(str string) (OrchestratorSortKey, error) {
+	switch key := OrchestratorSortKey(str); key {
+	case SortBySuccessRate, SortByAttempts, SortByErrors, SortByLatencyP50, SortByLatencyP95, SortByLatencyP99:
+		return key, nil
+	default:
+		return "", fmt.Errorf("unknown orchestrator sort key: %q", str)
+	}
+}
+
+// OrchestratorStats aggregates the transcode attempts of all streams by the
+// orchestrator address, over sliding time windows.
+type OrchestratorStats struct {
+	windows []time.Duration
+
+	mu    sync.Mutex
+	orchs map[string]*orchestratorAggrs
+	// latest is the timestamp of the latest transcode event, used as the end of
+	// the windows so the stats work the same when replaying past events.
+	latest, lastPrune time.Time
+}
+
+type orchestratorAggrs struct {
+	transcoderUri string
+	lastSeen      time.Time
+	// success has 1 for each successful attempt and 0 for each failed one.
+	success stats.WindowAggregators
+	// latency has the latency in milliseconds of the successful attempts.
+	latency stats.WindowAggregators
+}
+
+// OrchestratorSummary are the stats of the transcode attempts sent to an
+// orchestrator, by time window.
+type OrchestratorSummary struct {
+	Address       string                                   `json:"address"`
+	TranscoderUri string                                   `json:"transcoderUri"`
+	LastSeen      data.UnixMillisTime                      `json:"lastSeen"`
+	Windows       map[stats.Window]OrchestratorWindowStats `json:"windows"`
+}
+
+type OrchestratorWindowStats struct {
+	Attempts    int          `json:"attempts"`
+	Errors      int          `json:"errors"`
+	SuccessRate float64      `json:"successRate"`
+	LatencyMs   stats.Values `json:"latencyMs"`
+}
+
+func NewOrchestratorStats(windows []time.Duration) *OrchestratorStats {
+	return &OrchestratorStats{windows: windows, orchs: map[string]*orchestratorAggrs{}}
+}
+
+func (o *OrchestratorStats) Windows() []time.Duration {
+	return o.windows
+}
+
+func (o *OrchestratorStats) newAggrs() stats.WindowAggregators {
+	aggrs := make(stats.WindowAggregators, len(o.windows))
+	for _, window := range o.windows {
+		aggrs[stats.Window{Duration: window}] = stats.NewBucketedAggregator(window, window/orchestratorStatsBuckets)
+	}
+	return aggrs
+}
+
+// HandleEvent adds the transcode attempts of the event to the stats of their
+// orchestrators. Other events are ignored.
+func (o *OrchestratorStats) HandleEvent(evt data.Event) {
+	transcode, ok := evt.(*data.TranscodeEvent)
+	if !ok || len(transcode.Attempts) == 0 {
+		return
+	}
+	ts := transcode.Timestamp()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if ts.After(o.latest) {
+		o.latest = ts
+	}
+	for _, attempt := range transcode.Attempts {
+		addr := attempt.Orchestrator.Address
+		if addr == "" {
+			continue
+		}
+		aggrs, ok := o.orchs[addr]
+		if !ok {
+			aggrs = &orchestratorAggrs{success: o.newAggrs(), latency: o.newAggrs()}
+			o.orchs[addr] = aggrs
+		}
+		if ts.After(aggrs.lastSeen) {
+			aggrs.lastSeen = ts
+			aggrs.transcoderUri = attempt.Orchestrator.TranscoderUri
+		}
+		success, latency := 0.0, float64(attempt.LatencyMs)
+		if attempt.Error == nil {
+			success = 1
+			for _, aggr := range aggrs.latency {
+				aggr.Add(ts, latency)
+			}
+		}
+		for _, aggr := range aggrs.success {
+			aggr.Add(ts, success)
+		}
+	}
+	if maxWindow := o.maxWindow(); o.latest.Sub(o.lastPrune) > maxWindow {
+		o.pruneLocked(o.latest.Add(-maxWindow))
+		o.lastPrune = o.latest
+	}
+}
+
+func (o *OrchestratorStats) maxWindow() time.Duration {
+	max := time.Duration(0)
+	for _, window := range o.windows {
+		if window > max {
+			max = window
+		}
+	}
+	return max
+}
+
+// pruneLocked removes the orchestrators not seen since the threshold, which
+// have no attempts in any window anymore.
+func (o *OrchestratorStats) pruneLocked(threshold time.Time) {
+	for addr, aggrs := range o.orchs {
+		if aggrs.lastSeen.Before(threshold) {
+			delete(o.orchs, addr)
+		}
+	}
+}
+
+// OrchestratorsOptions sorts and filters the orchestrators listed by List.
+type OrchestratorsOptions struct {
+	// Window is the one used for sorting and filtering. Must be one of the
+	// windows of the stats.
+	Window    time.Duration
+	SortBy    OrchestratorSortKey
+	Ascending bool
+	// MinAttempts filters out the orchestrators with less attempts in the
+	// window.
+	MinAttempts int
+	Limit       int
+}
+
+// List returns the stats of the orchestrators sorted by the given key.
+// Orchestrators with the same value are sorted by address.
+func (o *OrchestratorStats) List(opts OrchestratorsOptions) ([]OrchestratorSummary, error) {
+	if !o.hasWindow(opts.Window) {
+		return nil, fmt.Errorf("unknown window %s", opts.Window)
+	} else if opts.SortBy == "" {
+		opts.SortBy = SortBySuccessRate
+	}
+	sortWindow := stats.Window{Duration: opts.Window}
+
+	o.mu.Lock()
+	summaries := make([]OrchestratorSummary, 0, len(o.orchs))
+	for addr, aggrs := range o.orchs {
+		summary := OrchestratorSummary{
+			Address:       addr,
+			TranscoderUri: aggrs.transcoderUri,
+			LastSeen:      data.UnixMillisTime{Time: aggrs.lastSeen},
+			Windows:       make(map[stats.Window]OrchestratorWindowStats, len(o.windows)),
+		}
+		for _, window := range o.windows {
+			summary.Windows[stats.Window{Duration: window}] = aggrs.windowStats(o.latest, window)
+		}
+		if summary.Windows[sortWindow].Attempts >= opts.MinAttempts {
+			summaries = append(summaries, summary)
+		}
+	}
+	o.mu.Unlock()
+
+	sort.Slice(summaries, func(i, j int) bool {
+		vi := summaries[i].Windows[sortWindow].sortValue(opts.SortBy)
+		vj := summaries[j].Windows[sortWindow].sortValue(opts.SortBy)
+		if vi != vj {
+			return (vi < vj) == opts.Ascending
+		}
+		return summaries[i].Address < summaries[j].Address
+	})
+	if opts.Limit > 0 && len(summaries) > opts.Limit {
+		summaries = summaries[:opts.Limit]
+	}
+	return summaries, nil
+}
+
+func (o *OrchestratorStats) hasWindow(window time.Duration) bool {
+	for _, w := range o.windows {
+		if w == window {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *orchestratorAggrs) windowStats(end time.Time, window time.Duration) OrchestratorWindowStats {
+	success := a.success[stats.Window{Duration: window}].ClipAt(end, window)
+	latency := a.latency[stats.Window{Duration: window}].ClipAt(end, window)
+
+	attempts := success.Count()
+	result := OrchestratorWindowStats{
+		Attempts:  attempts,
+		Errors:    attempts - int(success.Sum()+0.5),
+		LatencyMs: make(stats.Values, len(orchestratorLatencyStats)),
+	}
+	if attempts > 0 {
+		result.SuccessRate = success.Average()
+	}
+	for _, stat := range orchestratorLatencyStats {
+		result.LatencyMs[stat] = latency.Compute(stat)
+	}
+	return result
+}
+
+func (s OrchestratorWindowStats) sortValue(key OrchestratorSortKey) float64 {
+	switch key {
+	case SortByAttempts:
+		return float64(s.Attempts)
+	case SortByErrors:
+		return float64(s.Errors)
+	case SortByLatencyP50:
+		return s.LatencyMs[stats.PercentileStat(50)]
+	case SortByLatencyP95:
+		return s.LatencyMs[stats.PercentileStat(95)]
+	case SortByLatencyP99:
+		return s.LatencyMs[stats.PercentileStat(99)]
+	default:
+		return s.SuccessRate
+	}
+}
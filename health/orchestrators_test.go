@@ -0,0 +1,82 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/stats"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrchestratorStatsList(t *testing.T) {
+	require := require.New(t)
+	orchStats := NewOrchestratorStats([]time.Duration{time.Minute, time.Hour})
+
+	start := time.Now().Truncate(time.Millisecond)
+	errMsg := "transcode failed"
+	attempt := func(addr string, latencyMs int64, failed bool) data.TranscodeAttemptInfo {
+		info := data.TranscodeAttemptInfo{
+			Orchestrator: data.OrchestratorMetadata{Address: addr, TranscoderUri: "https://" + addr},
+			LatencyMs:    latencyMs,
+		}
+		if failed {
+			info.Error = &errMsg
+		}
+		return info
+	}
+	transcode := func(offset time.Duration, attempts ...data.TranscodeAttemptInfo) {
+		evt := data.NewTranscodeEvent("node", "stream", data.SegmentMetadata{}, start.Add(offset), true, attempts)
+		evt.Timestamp_ = data.UnixMillisTime{Time: start.Add(offset)}
+		orchStats.HandleEvent(evt)
+	}
+
+	// orch-a fails half of the attempts in the last hour, but none in the last
+	// minute. orch-b never fails but is slower.
+	transcode(0, attempt("orch-a", 100, true), attempt("orch-b", 500, false))
+	transcode(10*time.Minute, attempt("orch-a", 100, true))
+	transcode(20*time.Minute, attempt("orch-a", 100, false), attempt("orch-b", 400, false))
+	transcode(30*time.Minute, attempt("orch-a", 200, false), attempt("orch-b", 600, false))
+	orchStats.HandleEvent(data.NewStreamStateEvent("node", "region", "user", "stream", data.StreamState{}))
+
+	list, err := orchStats.List(OrchestratorsOptions{Window: time.Hour})
+	require.NoError(err)
+	require.Len(list, 2)
+	require.Equal("orch-b", list[0].Address)
+	require.Equal("https://orch-b", list[0].TranscoderUri)
+	hour := list[1].Windows[stats.Window{Duration: time.Hour}]
+	require.Equal("orch-a", list[1].Address)
+	require.Equal(4, hour.Attempts)
+	require.Equal(2, hour.Errors)
+	require.InDelta(0.5, hour.SuccessRate, 1e-9)
+	require.InDelta(150, hour.LatencyMs[stats.StatAverage], 1e-9)
+
+	minute := list[1].Windows[stats.Window{Duration: time.Minute}]
+	require.Equal(1, minute.Attempts)
+	require.Equal(0, minute.Errors)
+	require.InDelta(200, minute.LatencyMs[stats.StatAverage], 1e-9)
+
+	list, err = orchStats.List(OrchestratorsOptions{Window: time.Hour, SortBy: SortByErrors, Limit: 1})
+	require.NoError(err)
+	require.Len(list, 1)
+	require.Equal("orch-a", list[0].Address)
+
+	list, err = orchStats.List(OrchestratorsOptions{Window: time.Hour, SortBy: SortByLatencyP50, Ascending: true})
+	require.NoError(err)
+	require.Equal("orch-a", list[0].Address)
+
+	list, err = orchStats.List(OrchestratorsOptions{Window: time.Hour, MinAttempts: 4})
+	require.NoError(err)
+	require.Len(list, 1)
+	require.Equal("orch-a", list[0].Address)
+
+	_, err = orchStats.List(OrchestratorsOptions{Window: 5 * time.Minute})
+	require.Error(err)
+
+	// orchestrators are pruned once out of every window
+	transcode(3*time.Hour, attempt("orch-c", 100, false))
+	list, err = orchStats.List(OrchestratorsOptions{Window: time.Hour})
+	require.NoError(err)
+	require.Len(list, 1)
+	require.Equal("orch-c", list[0].Address)
+}
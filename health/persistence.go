@@ -0,0 +1,457 @@
+package health
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/stats"
+)
+
+const (
+	snapshotFileName = "records.snapshot"
+	walFileName      = "records.wal"
+)
+
+// PersistenceOptions configure the durable storage of the stream records. Only
+// the stream records are persisted: the TaskTracker and OrchestratorStats state
+// is volatile and lost on restarts, except for the events replayed from the
+// write-ahead log since the last snapshot.
+type PersistenceOptions struct {
+	// Dir is the directory where the records snapshot and write-ahead log are
+	// stored. Persistence is disabled if empty.
+	Dir string
+	// SnapshotInterval is the period for dumping a full snapshot of the records
+	// and truncating the write-ahead log.
+	SnapshotInterval time.Duration
+}
+
+// Reducer states are persisted with encoding/gob, so reducers that keep state
+// must make sure it is encodable and call gob.Register for its concrete types.
+// States that fail to encode are dropped from the snapshot with an error log.
+type recordsSnapshot struct {
+	Offset  int64
+	Records []recordSnapshot
+}
+
+type recordSnapshot struct {
+	ID             string
+	LastStatus     *statusSnapshot
+	NodeID, Region string
+	ReducerState   []byte
+	PastEvents     []json.RawMessage
+	Sessions       []sessionSnapshot
+
+	ConditionHistory map[data.ConditionType][]ConditionTransition
+}
+
+type sessionSnapshot struct {
+	ID           string
+	StartTime    time.Time
+	LastStatus   *statusSnapshot
+	ReducerState []byte
+}
+
+type reducerStateGob struct {
+	State interface{}
+}
+
+// walEntry is either an event or a reducer tick, which must be logged as well
+// since they change the status of the streams.
+type walEntry struct {
+	Offset int64           `json:"offset"`
+	Event  json.RawMessage `json:"event,omitempty"`
+	Tick   *time.Time      `json:"tick,omitempty"`
+}
+
+// recordsPersistence implements durable storage of the health records through
+// periodic snapshots plus a write-ahead log of all the events processed since
+// the last snapshot. The lock must be held by whoever is mutating records so
+// the snapshots are consistent with the log.
+type recordsPersistence struct {
+	sync.Mutex
+	dir    string
+	wal    *os.File
+	walBuf *bufio.Writer
+}
+
+func openRecordsPersistence(dir string) (*recordsPersistence, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating persistence dir: %w", err)
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening write-ahead log: %w", err)
+	}
+	return &recordsPersistence{dir: dir, wal: wal, walBuf: bufio.NewWriter(wal)}, nil
+}
+
+// Restore reads the last snapshot and all the log entries written after it.
+// The returned snapshot is empty with an offset of -1 if none was saved yet.
+func (p *recordsPersistence) Restore() (*recordsSnapshot, []walEntry, error) {
+	snapshot := &recordsSnapshot{}
+	file, err := os.Open(filepath.Join(p.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		snapshot.Offset = -1
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("error opening snapshot: %w", err)
+	} else {
+		defer file.Close()
+		if err := gob.NewDecoder(bufio.NewReader(file)).Decode(snapshot); err != nil {
+			return nil, nil, fmt.Errorf("error decoding snapshot: %w", err)
+		}
+	}
+
+	if _, err := p.wal.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("error seeking write-ahead log: %w", err)
+	}
+	var entries []walEntry
+	decoder := json.NewDecoder(bufio.NewReader(p.wal))
+	for {
+		var entry walEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// most likely a partial write from a crash, so just ignore the tail.
+			glog.Warningf("Ignoring corrupted tail of write-ahead log. entries=%d err=%q", len(entries), err)
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return snapshot, entries, nil
+}
+
+func (p *recordsPersistence) AppendLocked(offset int64, rawEvt []byte) error {
+	return p.appendLocked(walEntry{Offset: offset, Event: rawEvt})
+}
+
+func (p *recordsPersistence) AppendTickLocked(offset int64, now time.Time) error {
+	return p.appendLocked(walEntry{Offset: offset, Tick: &now})
+}
+
+func (p *recordsPersistence) appendLocked(entry walEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := p.walBuf.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing to write-ahead log: %w", err)
+	}
+	return nil
+}
+
+func (p *recordsPersistence) FlushLocked() error {
+	return p.walBuf.Flush()
+}
+
+// SnapshotLocked saves a snapshot of all the given records to disk and then
+// truncates the write-ahead log since all its entries are already contained in
+// the snapshot. The records will be read-locked one at a time while encoding.
+func (p *recordsPersistence) SnapshotLocked(offset int64, records []*Record) error {
+	snapshot := recordsSnapshot{Offset: offset, Records: make([]recordSnapshot, 0, len(records))}
+	for _, record := range records {
+		snapshot.Records = append(snapshot.Records, newRecordSnapshot(record))
+	}
+
+	tmpPath := filepath.Join(p.dir, snapshotFileName+".tmp")
+	if err := writeSnapshotFile(tmpPath, &snapshot); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(p.dir, snapshotFileName)); err != nil {
+		return fmt.Errorf("error replacing snapshot: %w", err)
+	}
+
+	if err := p.walBuf.Flush(); err != nil {
+		return fmt.Errorf("error flushing write-ahead log: %w", err)
+	}
+	if err := p.wal.Truncate(0); err != nil {
+		return fmt.Errorf("error truncating write-ahead log: %w", err)
+	}
+	return nil
+}
+
+func (p *recordsPersistence) Close() error {
+	p.Lock()
+	defer p.Unlock()
+	if err := p.walBuf.Flush(); err != nil {
+		p.wal.Close()
+		return err
+	}
+	if err := p.wal.Sync(); err != nil {
+		p.wal.Close()
+		return err
+	}
+	return p.wal.Close()
+}
+
+func newRecordSnapshot(record *Record) recordSnapshot {
+	record.RLock()
+	defer record.RUnlock()
+	snap := recordSnapshot{
+		ID:         record.ID,
+		LastStatus: newStatusSnapshot(record.LastStatus),
+		NodeID:     record.NodeID,
+		Region:     record.Region,
+		PastEvents: make([]json.RawMessage, 0, len(record.PastEvents)),
+
+		ConditionHistory: make(map[data.ConditionType][]ConditionTransition, len(record.ConditionHistory)),
+	}
+	for condType, history := range record.ConditionHistory {
+		snap.ConditionHistory[condType] = append([]ConditionTransition(nil), history...)
+	}
+	for _, evt := range record.PastEvents {
+		raw, err := json.Marshal(evt)
+		if err != nil {
+			glog.Errorf("Error encoding event for snapshot. id=%q eventId=%q err=%q", record.ID, evt.ID(), err)
+			continue
+		}
+		snap.PastEvents = append(snap.PastEvents, raw)
+	}
+	snap.ReducerState = encodeReducerState(record.ID, record.ReducerState)
+	for _, session := range record.Sessions {
+		snap.Sessions = append(snap.Sessions, sessionSnapshot{
+			ID:           session.ID,
+			StartTime:    session.StartTime,
+			LastStatus:   newStatusSnapshot(session.LastStatus),
+			ReducerState: encodeReducerState(record.ID, session.ReducerState),
+		})
+	}
+	return snap
+}
+
+func (s recordSnapshot) toRecord(conditions []data.ConditionType) *Record {
+	record := NewRecord(s.ID, conditions)
+	if s.LastStatus != nil {
+		record.LastStatus = s.LastStatus.toStatus()
+	}
+	record.NodeID, record.Region = s.NodeID, s.Region
+	for _, raw := range s.PastEvents {
+		evt, err := data.ParseEvent(raw)
+		if err != nil {
+			glog.Errorf("Error parsing event from snapshot. id=%q err=%q", s.ID, err)
+			continue
+		}
+		record.PastEvents = append(record.PastEvents, evt)
+		record.EventsByID[evt.ID()] = evt
+	}
+	record.ReducerState = decodeReducerState(s.ID, s.ReducerState)
+	for _, sessSnap := range s.Sessions {
+		session := newSessionRecord(s.ID, sessSnap.ID, sessSnap.StartTime, conditions)
+		if sessSnap.LastStatus != nil {
+			session.LastStatus = sessSnap.LastStatus.toStatus()
+		}
+		session.ReducerState = decodeReducerState(s.ID, sessSnap.ReducerState)
+		record.Sessions = append(record.Sessions, session)
+	}
+	for condType, history := range s.ConditionHistory {
+		record.ConditionHistory[condType] = history
+	}
+	return record
+}
+
+// transitionGob is the serialized form of a ConditionTransition, since gob
+// would decode a pointer to false as a nil pointer.
+type transitionGob struct {
+	Time                   time.Time
+	SessionID              string
+	PreviousKnown, Known   bool
+	PreviousStatus, Status bool
+}
+
+func (t ConditionTransition) GobEncode() ([]byte, error) {
+	enc := transitionGob{Time: t.Time, SessionID: t.SessionID}
+	if t.PreviousStatus != nil {
+		enc.PreviousKnown, enc.PreviousStatus = true, *t.PreviousStatus
+	}
+	if t.Status != nil {
+		enc.Known, enc.Status = true, *t.Status
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(enc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (t *ConditionTransition) GobDecode(raw []byte) error {
+	var dec transitionGob
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&dec); err != nil {
+		return err
+	}
+	*t = ConditionTransition{Time: dec.Time, SessionID: dec.SessionID}
+	if dec.PreviousKnown {
+		t.PreviousStatus = &dec.PreviousStatus
+	}
+	if dec.Known {
+		t.Status = &dec.Status
+	}
+	return nil
+}
+
+// statusSnapshot is the serialized form of a data.HealthStatus. It only exists
+// because gob omits zero values, so it would decode a condition status pointing
+// to false as a nil (unknown) status.
+type statusSnapshot struct {
+	ID          string
+	SessionID   string
+	Healthy     *conditionSnapshot
+	Conditions  []*conditionSnapshot
+	Metrics     data.MetricsMap
+	Multistream []multistreamSnapshot
+}
+
+type conditionSnapshot struct {
+	Type               data.ConditionType
+	Known, Status      bool
+	ExtraData          interface{}
+	Frequency          stats.ByWindow
+	LastProbeTime      *data.UnixMillisTime
+	LastTransitionTime *data.UnixMillisTime
+}
+
+type multistreamSnapshot struct {
+	Target    data.MultistreamTargetInfo
+	Connected *conditionSnapshot
+	Stalled   *conditionSnapshot
+}
+
+func newStatusSnapshot(status *data.HealthStatus) *statusSnapshot {
+	if status == nil {
+		return nil
+	}
+	snap := &statusSnapshot{
+		ID:          status.ID,
+		SessionID:   status.SessionID,
+		Healthy:     newConditionSnapshot(status.Healthy),
+		Conditions:  make([]*conditionSnapshot, len(status.Conditions)),
+		Metrics:     status.Metrics,
+		Multistream: make([]multistreamSnapshot, len(status.Multistream)),
+	}
+	for i, cond := range status.Conditions {
+		snap.Conditions[i] = newConditionSnapshot(cond)
+	}
+	for i, ms := range status.Multistream {
+		snap.Multistream[i] = multistreamSnapshot{
+			Target:    ms.Target,
+			Connected: newConditionSnapshot(ms.Connected),
+			Stalled:   newConditionSnapshot(ms.Stalled),
+		}
+	}
+	return snap
+}
+
+func (s *statusSnapshot) toStatus() *data.HealthStatus {
+	status := &data.HealthStatus{
+		ID:          s.ID,
+		SessionID:   s.SessionID,
+		Healthy:     s.Healthy.toCondition(),
+		Conditions:  make([]*data.Condition, len(s.Conditions)),
+		Metrics:     s.Metrics,
+		Multistream: make([]*data.MultistreamStatus, len(s.Multistream)),
+	}
+	if status.Metrics == nil {
+		status.Metrics = data.MetricsMap{}
+	}
+	for i, cond := range s.Conditions {
+		status.Conditions[i] = cond.toCondition()
+	}
+	for i, ms := range s.Multistream {
+		status.Multistream[i] = &data.MultistreamStatus{
+			Target:    ms.Target,
+			Connected: ms.Connected.toCondition(),
+			Stalled:   ms.Stalled.toCondition(),
+		}
+	}
+	return status
+}
+
+func newConditionSnapshot(cond *data.Condition) *conditionSnapshot {
+	if cond == nil {
+		return nil
+	}
+	snap := &conditionSnapshot{
+		Type:               cond.Type,
+		Known:              cond.Status != nil,
+		ExtraData:          cond.ExtraData,
+		Frequency:          cond.Frequency,
+		LastProbeTime:      cond.LastProbeTime,
+		LastTransitionTime: cond.LastTransitionTime,
+	}
+	if cond.Status != nil {
+		snap.Status = *cond.Status
+	}
+	return snap
+}
+
+func (s *conditionSnapshot) toCondition() *data.Condition {
+	if s == nil {
+		return nil
+	}
+	cond := &data.Condition{
+		Type:               s.Type,
+		ExtraData:          s.ExtraData,
+		Frequency:          s.Frequency,
+		LastProbeTime:      s.LastProbeTime,
+		LastTransitionTime: s.LastTransitionTime,
+	}
+	if s.Known {
+		status := s.Status
+		cond.Status = &status
+	}
+	return cond
+}
+
+func encodeReducerState(id string, state interface{}) []byte {
+	if state == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(reducerStateGob{state}); err != nil {
+		glog.Errorf("Error encoding reducer state for snapshot. id=%q err=%q", id, err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func decodeReducerState(id string, raw []byte) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	var state reducerStateGob
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&state); err != nil {
+		glog.Errorf("Error decoding reducer state from snapshot. id=%q err=%q", id, err)
+		return nil
+	}
+	return state.State
+}
+
+func writeSnapshotFile(path string, snapshot *recordsSnapshot) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating snapshot: %w", err)
+	}
+	defer file.Close()
+
+	buf := bufio.NewWriter(file)
+	if err := gob.NewEncoder(buf).Encode(snapshot); err != nil {
+		return fmt.Errorf("error encoding snapshot: %w", err)
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("error writing snapshot: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("error syncing snapshot: %w", err)
+	}
+	return nil
+}
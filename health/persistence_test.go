@@ -0,0 +1,183 @@
+package health
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
+	streamAmqp "github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+var countingReducer = ReducerFunc(func(current *data.HealthStatus, state interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
+	count, _ := state.(int)
+	return current, count + 1
+})
+
+const condActive data.ConditionType = "Active"
+
+// activeReducer sets the Active condition from the stream state events.
+var activeReducer = ReducerFunc(func(current *data.HealthStatus, state interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
+	active := evt.(*data.StreamStateEvent).State.Active
+	conditions := current.ConditionsCopy()
+	conditions[0] = data.NewCondition(condActive, evt.Timestamp(), &active, current.Condition(condActive))
+	return data.NewMergedHealthStatus(current, data.HealthStatus{Conditions: conditions}), state
+})
+
+func newPersistedCore(t *testing.T, dir string) *Core {
+	persistence, err := openRecordsPersistence(dir)
+	require.NoError(t, err)
+	core := &Core{
+		reducer:     countingReducer,
+		storage:     NewMapRecordStorage(),
+		persistence: persistence,
+		lastOffset:  -1,
+		opts:        CoreOptions{StartTimeOffset: time.Hour},
+	}
+	core.workers = newEventWorkers(2, 10, core.processEvent)
+	return core
+}
+
+func handleEvents(t *testing.T, core *Core, events ...data.Event) {
+	for _, evt := range events {
+		raw, err := json.Marshal(evt)
+		require.NoError(t, err)
+		core.HandleMessage(event.StreamMessage{Message: &streamAmqp.Message{Data: [][]byte{raw}}})
+	}
+}
+
+func TestPersistenceRestoresSnapshotAndLog(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+
+	core := newPersistedCore(t, dir)
+	newEvt := func() data.Event {
+		return data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: true})
+	}
+	handleEvents(t, core, newEvt(), newEvt(), newEvt())
+	require.NoError(core.snapshot())
+	handleEvents(t, core, newEvt(), newEvt())
+	core.workers.Close()
+	require.NoError(core.persistence.Close())
+
+	restored := newPersistedCore(t, dir)
+	require.NoError(restored.restore())
+	defer restored.persistence.Close()
+	defer restored.workers.Close()
+
+	record, ok := restored.storage.Get("stream-1")
+	require.True(ok)
+	require.Equal(5, record.ReducerState)
+	require.Len(record.PastEvents, 5)
+	require.Len(record.EventsByID, 5)
+	require.Equal("stream-1", record.LastStatus.ID)
+
+	// re-processing an already restored event is a no-op
+	handleEvents(t, restored, record.PastEvents[4])
+	restored.workers.WaitPending()
+	require.Equal(5, record.ReducerState)
+}
+
+func TestPersistenceRestoresEmptySnapshotOffset(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+
+	core := newPersistedCore(t, dir)
+	require.NoError(core.restore())
+	require.EqualValues(-1, core.lastOffset)
+
+	// a snapshot without records must still resume from its offset, including
+	// the first offset of the stream which gob encodes as a zero value.
+	for _, offset := range []int64{0, 42} {
+		core.lastOffset = offset
+		require.NoError(core.snapshot())
+
+		restored := newPersistedCore(t, dir)
+		require.NoError(restored.restore())
+		require.Equal(offset, restored.lastOffset)
+		restored.workers.Close()
+		require.NoError(restored.persistence.Close())
+	}
+	core.workers.Close()
+	require.NoError(core.persistence.Close())
+}
+
+func TestPersistenceSnapshotKeepsFalseStatuses(t *testing.T) {
+	require := require.New(t)
+	persistence, err := openRecordsPersistence(t.TempDir())
+	require.NoError(err)
+	defer persistence.Close()
+
+	condTypes := []data.ConditionType{"Active", "Transcoding"}
+	record := NewRecord("stream-1", condTypes)
+	active, transcoding := false, true
+	ts := time.Now()
+	record.LastStatus = data.NewMergedHealthStatus(record.LastStatus, data.HealthStatus{
+		Healthy: data.NewCondition("", ts, &active, record.LastStatus.Healthy),
+		Conditions: []*data.Condition{
+			data.NewCondition("Active", ts, &active, nil),
+			data.NewCondition("Transcoding", ts, &transcoding, nil),
+		},
+	})
+	wasActive := true
+	record.AddTransitionLocked("Active", ConditionTransition{Time: ts.Add(-time.Second), Status: &wasActive}, 10)
+	record.AddTransitionLocked("Active", ConditionTransition{Time: ts, PreviousStatus: &wasActive, Status: &active}, 10)
+	require.NoError(persistence.SnapshotLocked(0, []*Record{record}))
+
+	snapshot, _, err := persistence.Restore()
+	require.NoError(err)
+	require.Len(snapshot.Records, 1)
+	restored := snapshot.Records[0].toRecord(condTypes)
+
+	// gob omits zero values, so false statuses must not come back as unknown
+	require.NotNil(restored.LastStatus.Healthy.Status)
+	require.False(*restored.LastStatus.Healthy.Status)
+	require.NotNil(restored.LastStatus.Condition("Active").Status)
+	require.False(*restored.LastStatus.Condition("Active").Status)
+	require.True(*restored.LastStatus.Condition("Transcoding").Status)
+	require.True(ts.Equal(restored.LastStatus.Condition("Active").LastTransitionTime.Time))
+
+	history := restored.ConditionHistory["Active"]
+	require.Len(history, 2)
+	require.Nil(history[0].PreviousStatus)
+	require.True(*history[0].Status)
+	require.True(*history[1].PreviousStatus)
+	require.False(*history[1].Status)
+}
+
+func TestPersistenceReplayDoesNotPublish(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+
+	core := newPersistedCore(t, dir)
+	core.reducer, core.conditionTypes = activeReducer, []data.ConditionType{condActive}
+	start := time.Now()
+	for i, active := range []bool{true, false} {
+		evt := data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: active})
+		evt.Timestamp_ = data.UnixMillisTime{Time: start.Add(time.Duration(i) * time.Second)}
+		handleEvents(t, core, evt)
+	}
+	core.workers.Close()
+	require.NoError(core.persistence.Close())
+
+	producer := &recordingProducer{}
+	restored := newPersistedCore(t, dir)
+	restored.reducer, restored.conditionTypes = activeReducer, []data.ConditionType{condActive}
+	restored.firehose = newFirehose(firehoseBufferSize, OverflowGap)
+	restored.opts.TransitionNotifier = NewTransitionNotifier(TransitionNotifierOptions{}, producer)
+	defer restored.opts.TransitionNotifier.Close()
+	require.NoError(restored.restore())
+	defer restored.persistence.Close()
+	defer restored.workers.Close()
+
+	status, err := restored.GetStatus("stream-1")
+	require.NoError(err)
+	require.False(*status.Condition(condActive).Status)
+
+	// the transitions and events were already published before the restart
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(producer.transitions())
+	require.Empty(restored.firehose.past)
+}
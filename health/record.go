@@ -5,10 +5,8 @@ import (
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/google/uuid"
 	"github.com/livepeer/livepeer-data/pkg/data"
-	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Record struct {
@@ -16,34 +14,116 @@ type Record struct {
 	Conditions []data.ConditionType
 
 	sync.RWMutex
-	disposed chan struct{}
+	disposed    chan struct{}
+	disposeOnce sync.Once
 
 	PastEvents []data.Event
 	EventsByID map[uuid.UUID]data.Event
-	EventSubs  []chan<- data.Event
+	EventSubs  []*EventSubscription
+	// StatusSubs receive the LastStatus every time it changes.
+	StatusSubs []chan *data.HealthStatus
+
+	ReducerState interface{}
+	LastStatus   *data.HealthStatus
+	// NodeID and Region are from the last event of the stream that had them.
+	NodeID, Region string
+
+	// Sessions has the health state of each session of the stream, sorted by the
+	// time they were first seen. When the stream has sessions, LastStatus is the
+	// status of the last one and the record ReducerState is unused.
+	Sessions []*SessionRecord
+
+	// ConditionHistory has the last transitions of each condition of the stream,
+	// sorted by time. The Healthy condition is keyed by data.ConditionHealthy.
+	ConditionHistory map[data.ConditionType][]ConditionTransition
+}
+
+// ConditionTransition is a change in the status of a condition of a stream.
+type ConditionTransition struct {
+	Time           time.Time
+	SessionID      string
+	PreviousStatus *bool
+	Status         *bool
+}
+
+// SessionRecord is the health state of a single session of a stream. It is
+// protected by the lock of the stream record that contains it.
+type SessionRecord struct {
+	ID        string
+	StartTime time.Time
 
 	ReducerState interface{}
 	LastStatus   *data.HealthStatus
 }
 
 func NewRecord(id string, conditionTypes []data.ConditionType) *Record {
-	conditions := make([]*data.Condition, len(conditionTypes))
-	for i, cond := range conditionTypes {
-		conditions[i] = data.NewCondition(cond, time.Time{}, nil, nil)
-	}
 	return &Record{
 		ID:         id,
 		Conditions: conditionTypes,
 		disposed:   make(chan struct{}),
 		EventsByID: map[uuid.UUID]data.Event{},
-		LastStatus: data.NewHealthStatus(id, conditions),
+		LastStatus: newInitialStatus(id, conditionTypes),
+
+		ConditionHistory: map[data.ConditionType][]ConditionTransition{},
 	}
 }
 
-func (r *Record) SubscribeLocked(ctx context.Context, subs chan data.Event) chan data.Event {
+func newInitialStatus(id string, conditionTypes []data.ConditionType) *data.HealthStatus {
+	conditions := make([]*data.Condition, len(conditionTypes))
+	for i, cond := range conditionTypes {
+		conditions[i] = data.NewCondition(cond, time.Time{}, nil, nil)
+	}
+	return data.NewHealthStatus(id, conditions)
+}
+
+func newSessionRecord(streamID, sessionID string, startTime time.Time, conditionTypes []data.ConditionType) *SessionRecord {
+	status := newInitialStatus(streamID, conditionTypes)
+	status.SessionID = sessionID
+	return &SessionRecord{
+		ID:         sessionID,
+		StartTime:  startTime,
+		LastStatus: status,
+	}
+}
+
+func (r *Record) SessionLocked(sessionID string) (*SessionRecord, bool) {
+	for _, session := range r.Sessions {
+		if session.ID == sessionID {
+			return session, true
+		}
+	}
+	return nil, false
+}
+
+// CurrentSessionLocked returns the last session of the stream, if any.
+func (r *Record) CurrentSessionLocked() *SessionRecord {
+	if len(r.Sessions) == 0 {
+		return nil
+	}
+	return r.Sessions[len(r.Sessions)-1]
+}
+
+// AddTransitionLocked appends a transition to the history of the condition,
+// keeping only the last maxLen ones.
+func (r *Record) AddTransitionLocked(condType data.ConditionType, transition ConditionTransition, maxLen int) {
+	history := r.ConditionHistory[condType]
+	insertIdx := len(history)
+	for insertIdx > 0 && transition.Time.Before(history[insertIdx-1].Time) {
+		insertIdx--
+	}
+	history = append(history, ConditionTransition{})
+	copy(history[insertIdx+1:], history[insertIdx:])
+	history[insertIdx] = transition
+
+	if len(history) > maxLen {
+		history = history[len(history)-maxLen:]
+	}
+	r.ConditionHistory[condType] = history
+}
+
+func (r *Record) SubscribeLocked(ctx context.Context, subs *EventSubscription) *EventSubscription {
 	r.EventSubs = append(r.EventSubs, subs)
 	go func() {
-		defer close(subs)
 		select {
 		case <-ctx.Done():
 		case <-r.disposed:
@@ -51,73 +131,67 @@ func (r *Record) SubscribeLocked(ctx context.Context, subs chan data.Event) chan
 
 		r.Lock()
 		defer r.Unlock()
-		for i := range r.EventSubs {
-			if subs == r.EventSubs[i] {
-				r.EventSubs = append(r.EventSubs[:i], r.EventSubs[i+1:]...)
-				return
-			}
-		}
+		subs.close()
+		r.EventSubs = removeSubscription(r.EventSubs, subs)
 	}()
 	return subs
 }
 
-type RecordStorage struct {
-	records   sync.Map
-	SizeGauge prometheus.Gauge
+// PublishLocked sends the event to all the subscriptions of the record,
+// removing the ones disconnected due to their overflow policy.
+func (r *Record) PublishLocked(evt data.Event) {
+	for i := 0; i < len(r.EventSubs); {
+		if r.EventSubs[i].send(evt) {
+			i++
+			continue
+		}
+		r.EventSubs = append(r.EventSubs[:i], r.EventSubs[i+1:]...)
+	}
 }
 
-func (s *RecordStorage) StartCleanupLoop(ctx context.Context, ttl time.Duration) {
+// SubscribeStatusLocked returns a channel that receives the last status of the
+// record every time it changes, keeping only the latest one for slow
+// subscribers. It is closed when the context is done or the record disposed.
+func (r *Record) SubscribeStatusLocked(ctx context.Context) <-chan *data.HealthStatus {
+	subs := make(chan *data.HealthStatus, 1)
+	r.StatusSubs = append(r.StatusSubs, subs)
 	go func() {
-		ticker := time.NewTicker(ttl / 100)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				threshold := time.Now().Add(-ttl)
-				recordsSize := 0
-				s.records.Range(func(key interface{}, value interface{}) bool {
-					recordsSize++
-					record := value.(*Record)
-					lastProbeTime := record.LastStatus.Healthy.LastProbeTime
-					if lastProbeTime != nil && lastProbeTime.Before(threshold) {
-						glog.Infof("Disposing of health record. id=%q, lastProbeTime=%q, ttl=%q", record.ID, lastProbeTime, ttl)
-						close(record.disposed)
-						s.records.Delete(key)
-						recordsSize--
-					}
-					return true
-				})
-				glog.Infof("Finished records clean-up loop. size=%d", recordsSize)
-				if s.SizeGauge != nil {
-					s.SizeGauge.Set(float64(recordsSize))
-				}
-			case <-ctx.Done():
-				// erase any dangling references
-				s.records = sync.Map{}
-				return
+		select {
+		case <-ctx.Done():
+		case <-r.disposed:
+		}
+
+		r.Lock()
+		defer r.Unlock()
+		for i := range r.StatusSubs {
+			if r.StatusSubs[i] == subs {
+				r.StatusSubs = append(r.StatusSubs[:i], r.StatusSubs[i+1:]...)
+				break
 			}
 		}
+		close(subs)
 	}()
+	return subs
 }
 
-func (s *RecordStorage) Get(id string) (*Record, bool) {
-	if saved, ok := s.records.Load(id); ok {
-		return saved.(*Record), true
+// PublishStatusLocked sends the LastStatus to all the status subscriptions.
+func (r *Record) PublishStatusLocked() {
+	for _, subs := range r.StatusSubs {
+		sendLatest(subs, r.LastStatus)
 	}
-	return nil, false
 }
 
-func (s *RecordStorage) GetOrCreate(id string, conditions []data.ConditionType) *Record {
-	if saved, ok := s.Get(id); ok {
-		return saved
-	}
-	new := NewRecord(id, conditions)
-	if actual, loaded := s.records.LoadOrStore(id, new); loaded {
-		return actual.(*Record)
-	}
-	glog.Infof("Created new health record. id=%q", id)
-	if s.SizeGauge != nil {
-		s.SizeGauge.Inc()
+func removeSubscription(subs []*EventSubscription, sub *EventSubscription) []*EventSubscription {
+	for i := range subs {
+		if subs[i] == sub {
+			return append(subs[:i], subs[i+1:]...)
+		}
 	}
-	return new
+	return subs
+}
+
+// Dispose ends all the event subscriptions of the record. It should be called
+// when the record is removed from the storage.
+func (r *Record) Dispose() {
+	r.disposeOnce.Do(func() { close(r.disposed) })
 }
@@ -1,6 +1,8 @@
 package health
 
 import (
+	"time"
+
 	"github.com/livepeer/livepeer-data/pkg/data"
 	"github.com/livepeer/livepeer-data/pkg/event"
 )
@@ -11,6 +13,15 @@ type Reducer interface {
 	Reduce(current *data.HealthStatus, state interface{}, evt data.Event) (*data.HealthStatus, interface{})
 }
 
+// TickReducer is an optional interface for reducers that also update the
+// status with the passage of time, like expiring conditions of streams that
+// stopped sending events. ReduceTick is called periodically for the streams
+// that have any condition with a true status, never concurrently with Reduce
+// for the same stream. It must return the current status if nothing changed.
+type TickReducer interface {
+	ReduceTick(current *data.HealthStatus, state interface{}, now time.Time) (*data.HealthStatus, interface{})
+}
+
 type ReducerFunc func(*data.HealthStatus, interface{}, data.Event) (*data.HealthStatus, interface{})
 
 func (f ReducerFunc) Bindings() []event.BindingArgs    { return nil }
@@ -0,0 +1,218 @@
+package reducers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/livepeer/livepeer-data/health"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the declarative configuration of the health reducers pipeline. It
+// can be loaded from a YAML or JSON file with LoadConfig, e.g.:
+//
+//	reducers: [stream_state, transcode, segments, ingest, multistream, media_server_metrics, expiry, health, stats]
+//	health:
+//	  - condition: Transcoding
+//	  - condition: TranscodeRealTime
+//	  - condition: Multistreaming
+//	    optional: true
+//	thresholds:
+//	  TranscodeRealTime: 1.2
+//	statsResolution: 5s
+//	conditionStaleness: 2m
+//	multistreamStallTimeout: 1m
+//
+// Any omitted field uses the same value as the default pipeline.
+type Config struct {
+	// Reducers are the names of the reducers to run, in order.
+	Reducers []string `json:"reducers" yaml:"reducers"`
+	// Health are the conditions considered for the Healthy condition.
+	Health []HealthRequirement `json:"health" yaml:"health"`
+	// Thresholds are numeric parameters of how some conditions are computed.
+	// Check conditionThresholds for the supported ones.
+	Thresholds map[data.ConditionType]float64 `json:"thresholds" yaml:"thresholds"`
+	// StatsResolution is the time resolution for aggregating the windowed stats
+	// of conditions and metrics, trading accuracy for memory usage. A negative
+	// value keeps every measure for exact stats.
+	StatsResolution time.Duration `yaml:"statsResolution"`
+	// ConditionStaleness is how long a condition keeps its status without being
+	// probed before the expiry reducer expires it. A negative value disables the
+	// expiry. Conditions are only expired if the core has a tick interval.
+	ConditionStaleness time.Duration `yaml:"conditionStaleness"`
+	// MultistreamStallTimeout is how long a connected multistream target can go
+	// without progress before it is considered stalled.
+	MultistreamStallTimeout time.Duration `yaml:"multistreamStallTimeout"`
+}
+
+type reducerFactory func(cfg Config, golpExchange string, shardPrefixes []string, streamStateExchange string) health.Reducer
+
+var reducerFactories = map[string]reducerFactory{
+	"stream_state": func(_ Config, _ string, _ []string, streamStateExchange string) health.Reducer {
+		return StreamStateReducer{streamStateExchange}
+	},
+	"transcode": func(cfg Config, golpExchange string, shardPrefixes []string, _ string) health.Reducer {
+		return TranscodeReducer{
+			GolpExchange:     golpExchange,
+			ShardPrefixes:    shardPrefixes,
+			MinRealtimeRatio: cfg.Thresholds[ConditionTranscodeRealTime],
+		}
+	},
+	"segments": func(Config, string, []string, string) health.Reducer {
+		return SegmentsReducer{}
+	},
+	"ingest": func(cfg Config, _ string, _ []string, _ string) health.Reducer {
+		return IngestReducer{
+			MinBitrateRatio:    cfg.Thresholds[ConditionIngestBitrateStable],
+			MaxSegmentDuration: cfg.Thresholds[ConditionSegmentDurationNormal],
+		}
+	},
+	"multistream": func(cfg Config, _ string, _ []string, _ string) health.Reducer {
+		return MultistreamReducer{
+			StallTimeout: cfg.MultistreamStallTimeout,
+		}
+	},
+	"media_server_metrics": func(Config, string, []string, string) health.Reducer {
+		return MediaServerMetrics{}
+	},
+	"expiry": func(cfg Config, _ string, _ []string, _ string) health.Reducer {
+		return ExpiryReducer{Staleness: cfg.ConditionStaleness}
+	},
+	"health": func(cfg Config, _ string, _ []string, _ string) health.Reducer {
+		return NewHealthReducer(cfg.Health)
+	},
+	"stats": func(cfg Config, _ string, _ []string, _ string) health.Reducer {
+		return StatsReducer(statsWindows, cfg.StatsResolution)
+	},
+}
+
+// conditionThresholds are the conditions that support a threshold, with the
+// description of what the threshold means for each of them.
+var conditionThresholds = map[data.ConditionType]string{
+	ConditionTranscodeRealTime:     "minimum ratio of segment duration to transcode latency",
+	ConditionIngestBitrateStable:   "minimum ratio of segment bitrate to its recent moving average",
+	ConditionSegmentDurationNormal: "maximum segment duration in seconds",
+}
+
+// reducerDependencies are the reducers that only work if some other reducer is
+// in the pipeline as well, since they rely on its event bindings.
+var reducerDependencies = map[string][]string{
+	"segments":    {"transcode"},
+	"ingest":      {"transcode"},
+	"multistream": {"media_server_metrics"},
+}
+
+// reducersOrder are the pairs of reducers that must run in this order when both
+// are in the pipeline, since the latter uses the conditions set by the former.
+var reducersOrder = [][2]string{
+	{"expiry", "health"},
+	{"health", "stats"},
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Reducers:   []string{"stream_state", "transcode", "segments", "ingest", "multistream", "media_server_metrics", "expiry", "health", "stats"},
+		Health:     defaultHealthRequirements,
+		Thresholds: map[data.ConditionType]float64{},
+
+		StatsResolution:         defaultStatsResolution,
+		ConditionStaleness:      defaultConditionStaleness,
+		MultistreamStallTimeout: defaultMultistreamStallTimeout,
+	}
+}
+
+// LoadConfig reads a pipeline config from a YAML or JSON file, filling any
+// omitted fields with the default config.
+func LoadConfig(path string) (Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading health config: %w", err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return Config{}, fmt.Errorf("error parsing health config: %w", err)
+	}
+
+	defaults := DefaultConfig()
+	if cfg.Reducers == nil {
+		cfg.Reducers = defaults.Reducers
+	}
+	if cfg.Health == nil {
+		cfg.Health = defaults.Health
+	}
+	if cfg.Thresholds == nil {
+		cfg.Thresholds = defaults.Thresholds
+	}
+	if cfg.StatsResolution == 0 {
+		cfg.StatsResolution = defaults.StatsResolution
+	}
+	if cfg.ConditionStaleness == 0 {
+		cfg.ConditionStaleness = defaults.ConditionStaleness
+	}
+	if cfg.MultistreamStallTimeout == 0 {
+		cfg.MultistreamStallTimeout = defaults.MultistreamStallTimeout
+	}
+	return cfg, nil
+}
+
+// FromConfig builds the reducers pipeline described by the config, validating
+// that all the reducers, conditions and thresholds in it are known and that the
+// reducers have their dependencies in the right order.
+func FromConfig(cfg Config, golpExchange string, shardPrefixes []string, streamStateExchange string) (health.Reducer, error) {
+	pipeline := make(Pipeline, 0, len(cfg.Reducers))
+	positions := map[string]int{}
+	for i, name := range cfg.Reducers {
+		factory, ok := reducerFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown reducer %q, must be one of %q", name, reducerNames())
+		} else if _, ok := positions[name]; ok {
+			return nil, fmt.Errorf("duplicate reducer %q", name)
+		}
+		positions[name] = i
+		pipeline = append(pipeline, factory(cfg, golpExchange, shardPrefixes, streamStateExchange))
+	}
+	for _, name := range cfg.Reducers {
+		for _, dep := range reducerDependencies[name] {
+			if _, ok := positions[dep]; !ok {
+				return nil, fmt.Errorf("reducer %q requires the %q reducer", name, dep)
+			}
+		}
+	}
+	for _, order := range reducersOrder {
+		first, okFirst := positions[order[0]]
+		second, okSecond := positions[order[1]]
+		if okFirst && okSecond && first > second {
+			return nil, fmt.Errorf("reducer %q must come before %q", order[0], order[1])
+		}
+	}
+
+	conditions := map[data.ConditionType]bool{}
+	for _, cond := range pipeline.Conditions() {
+		conditions[cond] = true
+	}
+	for _, req := range cfg.Health {
+		if !conditions[req.Condition] {
+			return nil, fmt.Errorf("health condition %q is not produced by any of the configured reducers", req.Condition)
+		}
+	}
+	for cond, value := range cfg.Thresholds {
+		if _, ok := conditionThresholds[cond]; !ok {
+			return nil, fmt.Errorf("threshold not supported for condition %q", cond)
+		} else if value < 0 {
+			return nil, fmt.Errorf("threshold for condition %q must not be negative, got %v", cond, value)
+		}
+	}
+	return pipeline, nil
+}
+
+func reducerNames() []string {
+	names := make([]string, 0, len(reducerFactories))
+	for name := range reducerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,54 @@
+package reducers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromConfigValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		reducers []string
+		err      string
+	}{
+		{"default", DefaultConfig().Reducers, ""},
+		{"unknown", []string{"stream_state", "magic"}, `unknown reducer "magic"`},
+		{"duplicate", []string{"transcode", "transcode"}, `duplicate reducer "transcode"`},
+		{"segments without transcode", []string{"segments", "health"}, `reducer "segments" requires the "transcode" reducer`},
+		{"ingest without transcode", []string{"ingest", "health"}, `reducer "ingest" requires the "transcode" reducer`},
+		{"multistream without metrics", []string{"multistream", "health"}, `reducer "multistream" requires the "media_server_metrics" reducer`},
+		{"dependency after", []string{"segments", "transcode", "health"}, ""},
+		{"health before expiry", []string{"transcode", "health", "expiry"}, `reducer "expiry" must come before "health"`},
+		{"stats before health", []string{"transcode", "stats", "health"}, `reducer "health" must come before "stats"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Reducers = tt.reducers
+			cfg.Health = nil
+			_, err := FromConfig(cfg, "", nil, "")
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDurations(t *testing.T) {
+	require := require.New(t)
+	path := filepath.Join(t.TempDir(), "health.yaml")
+	require.NoError(os.WriteFile(path, []byte("statsResolution: 10s\nmultistreamStallTimeout: 1m\n"), 0644))
+
+	cfg, err := LoadConfig(path)
+	require.NoError(err)
+	require.Equal(10*time.Second, cfg.StatsResolution)
+	require.Equal(time.Minute, cfg.MultistreamStallTimeout)
+	require.Equal(defaultConditionStaleness, cfg.ConditionStaleness)
+	require.Equal(DefaultConfig().Reducers, cfg.Reducers)
+}
@@ -1,6 +1,7 @@
 package reducers
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/livepeer/livepeer-data/health"
@@ -9,17 +10,16 @@ import (
 var (
 	statsWindows   = []time.Duration{1 * time.Minute, 10 * time.Minute}
 	maxStatsWindow = statsWindows[len(statsWindows)-1]
+	// defaultStatsResolution is the size of the buckets for aggregating stats.
+	defaultStatsResolution = 5 * time.Second
 )
 
 func Default(golpExchange string, shardPrefixes []string, streamStateExchange string) health.Reducer {
-	return Pipeline{
-		StreamStateReducer{streamStateExchange},
-		TranscodeReducer{golpExchange, shardPrefixes},
-		MultistreamReducer{},
-		MediaServerMetrics{},
-		HealthReducer,
-		StatsReducer(statsWindows),
+	reducer, err := FromConfig(DefaultConfig(), golpExchange, shardPrefixes, streamStateExchange)
+	if err != nil {
+		panic(fmt.Errorf("invalid default health config: %w", err))
 	}
+	return reducer
 }
 
 func DefaultStarTimeOffset() time.Duration {
@@ -0,0 +1,107 @@
+package reducers
+
+import (
+	"encoding/gob"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
+)
+
+const defaultConditionStaleness = 2 * time.Minute
+
+// eventDrivenConditions are only probed when they change instead of on every
+// event of the stream, so they only expire when the stream stops sending events
+// altogether.
+var eventDrivenConditions = map[data.ConditionType]bool{
+	ConditionActive:         true,
+	ConditionMultistreaming: true,
+}
+
+type expiryState struct {
+	LastEventTime time.Time
+}
+
+func init() {
+	gob.Register(&expiryState{})
+}
+
+// ExpiryReducer expires the conditions of the streams on ticks, so they don't
+// keep a stale status forever when their node stops sending events. Conditions
+// not probed within the staleness window become unknown, except for Active
+// which becomes false. It must come before the health reducer in the pipeline.
+// It does nothing unless the core is configured with a tick interval.
+type ExpiryReducer struct {
+	// Staleness is how long a condition keeps its status without a probe.
+	// Defaults to 2 minutes if zero and disables the expiry if negative.
+	Staleness time.Duration
+}
+
+func (t ExpiryReducer) Bindings() []event.BindingArgs {
+	return nil
+}
+
+func (t ExpiryReducer) Conditions() []data.ConditionType {
+	return nil
+}
+
+func (t ExpiryReducer) Reduce(current *data.HealthStatus, stateIface interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
+	state, _ := stateIface.(*expiryState)
+	if state == nil {
+		state = &expiryState{}
+	}
+	if ts := evt.Timestamp(); ts.After(state.LastEventTime) {
+		state.LastEventTime = ts
+	}
+	return current, state
+}
+
+func (t ExpiryReducer) ReduceTick(current *data.HealthStatus, stateIface interface{}, now time.Time) (*data.HealthStatus, interface{}) {
+	staleness := t.staleness()
+	if staleness < 0 {
+		return current, stateIface
+	}
+	threshold := now.Add(-staleness)
+	state, _ := stateIface.(*expiryState)
+	streamStale := state != nil && state.LastEventTime.Before(threshold)
+
+	var conditions []*data.Condition
+	for i, cond := range current.Conditions {
+		if cond.Status == nil {
+			continue
+		} else if eventDrivenConditions[cond.Type] {
+			if !streamStale {
+				continue
+			}
+		} else if cond.LastProbeTime != nil && !cond.LastProbeTime.Before(threshold) {
+			continue
+		}
+
+		if conditions == nil {
+			conditions = current.ConditionsCopy()
+		}
+		if cond.Type == ConditionActive {
+			inactive := false
+			conditions[i] = data.NewCondition(cond.Type, now, &inactive, cond)
+		} else {
+			conditions[i] = unknownCondition(cond, now)
+		}
+	}
+	if conditions == nil {
+		return current, stateIface
+	}
+	return data.NewMergedHealthStatus(current, data.HealthStatus{Conditions: conditions}), stateIface
+}
+
+// isExpired returns whether the condition was expired by the ExpiryReducer,
+// which is the only way a condition with a transition goes back to unknown.
+func isExpired(cond *data.Condition) bool {
+	return cond != nil && cond.Status == nil && cond.LastTransitionTime != nil
+}
+
+func (t ExpiryReducer) staleness() time.Duration {
+	if t.Staleness == 0 {
+		return defaultConditionStaleness
+	}
+	return t.Staleness
+}
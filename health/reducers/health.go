@@ -1,41 +1,97 @@
 package reducers
 
 import (
+	"time"
+
 	"github.com/livepeer/livepeer-data/health"
 	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
 )
 
 var trueValue = true
 
-type healthRequirement struct {
-	condition data.ConditionType
-	optional  bool
+// HealthRequirement is a condition considered for the Healthy condition of a
+// stream. The stream is unhealthy if any of the conditions is false, and
+// unknown if any of the required ones has no status yet.
+type HealthRequirement struct {
+	Condition data.ConditionType `json:"condition" yaml:"condition"`
+	Optional  bool               `json:"optional,omitempty" yaml:"optional,omitempty"`
 }
 
-var healthRequirements = []healthRequirement{
+var defaultHealthRequirements = []HealthRequirement{
 	{ConditionTranscoding, false},
 	{ConditionTranscodeRealTime, false},
 	{ConditionMultistreaming, true},
 }
 
-var HealthReducer = health.ReducerFunc(reduceHealth)
+var HealthReducer = NewHealthReducer(defaultHealthRequirements)
+
+// NewHealthReducer returns a reducer for the Healthy condition. It also
+// recomputes it on ticks, since other reducers can expire conditions then.
+func NewHealthReducer(requirements []HealthRequirement) health.Reducer {
+	return healthReducer{requirements}
+}
+
+type healthReducer struct {
+	requirements []HealthRequirement
+}
+
+func (r healthReducer) Bindings() []event.BindingArgs    { return nil }
+func (r healthReducer) Conditions() []data.ConditionType { return nil }
+
+func (r healthReducer) Reduce(current *data.HealthStatus, _ interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
+	status, _ := healthStatus(r.requirements, current)
+	healthy := data.NewCondition("", evt.Timestamp(), status, current.Healthy)
+	return data.NewMergedHealthStatus(current, data.HealthStatus{Healthy: healthy}), nil
+}
+
+// ReduceTick only updates the Healthy condition if its status changed, so the
+// ticks don't count as probes of the stream. It becomes unknown if any of the
+// required conditions has expired, since its last status is not valid anymore.
+func (r healthReducer) ReduceTick(current *data.HealthStatus, _ interface{}, now time.Time) (*data.HealthStatus, interface{}) {
+	status, expired := healthStatus(r.requirements, current)
+	var healthy *data.Condition
+	switch {
+	case status == nil && expired && current.Healthy != nil:
+		healthy = unknownCondition(current.Healthy, now)
+	case status != nil:
+		healthy = data.NewCondition("", now, status, current.Healthy)
+	default:
+		return current, nil
+	}
+	if current.Healthy != nil && healthy.LastTransitionTime == current.Healthy.LastTransitionTime {
+		return current, nil
+	}
+	return data.NewMergedHealthStatus(current, data.HealthStatus{Healthy: healthy}), nil
+}
 
-func reduceHealth(current *data.HealthStatus, _ interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
-	healthStatus := &trueValue
-	for _, req := range healthRequirements {
-		cond := current.Condition(req.condition)
+// healthStatus returns the status of the Healthy condition from the required
+// ones, and whether any required condition with an unknown status has expired.
+func healthStatus(requirements []HealthRequirement, current *data.HealthStatus) (status *bool, expired bool) {
+	status = &trueValue
+	for _, req := range requirements {
+		cond := current.Condition(req.Condition)
 		if cond == nil || cond.Status == nil {
-			if !req.optional {
-				healthStatus = nil
+			if !req.Optional {
+				status = nil
+				expired = expired || isExpired(cond)
 			}
 			continue
 		}
 		if !*cond.Status {
-			healthStatus = cond.Status
-			break
+			return cond.Status, false
 		}
 	}
-	healthyCond := data.NewCondition("", evt.Timestamp(), healthStatus, current.Healthy)
+	return status, expired
+}
 
-	return data.NewMergedHealthStatus(current, data.HealthStatus{Healthy: healthyCond}), nil
+// unknownCondition transitions the condition to an unknown status, which
+// data.NewCondition doesn't do since it ignores nil statuses.
+func unknownCondition(cond *data.Condition, ts time.Time) *data.Condition {
+	if cond.Status == nil {
+		return cond
+	}
+	newCond := *cond
+	newCond.Status, newCond.LastTransitionTime = nil, &data.UnixMillisTime{Time: ts}
+	return &newCond
 }
@@ -0,0 +1,62 @@
+package reducers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthReducerExpiredConditions(t *testing.T) {
+	require := require.New(t)
+	reducer := NewHealthReducer([]HealthRequirement{{ConditionTranscoding, false}, {ConditionMultistreaming, true}})
+	tickReducer := reducer.(healthReducer)
+	start := time.Now()
+
+	status := newTestStatus(ConditionTranscoding, ConditionMultistreaming)
+	status = setCondition(status, ConditionTranscoding, start, true)
+	status, _ = reducer.Reduce(status, nil, testEvent(start))
+	require.True(*status.Healthy.Status)
+
+	// unknown conditions that were never probed keep the last health status
+	status = data.NewMergedHealthStatus(status, data.HealthStatus{
+		Conditions: []*data.Condition{data.NewCondition(ConditionTranscoding, time.Time{}, nil, nil), status.Conditions[1]},
+	})
+	status, _ = reducer.Reduce(status, nil, testEvent(start.Add(time.Second)))
+	require.True(*status.Healthy.Status)
+	ticked, _ := tickReducer.ReduceTick(status, nil, start.Add(2*time.Second))
+	require.Same(status, ticked)
+
+	// expired ones make it unknown on ticks
+	status = setCondition(status, ConditionTranscoding, start, true)
+	expired, _ := ExpiryReducer{Staleness: time.Minute}.ReduceTick(status, nil, start.Add(2*time.Minute))
+	require.Nil(expired.Condition(ConditionTranscoding).Status)
+	ticked, _ = tickReducer.ReduceTick(expired, nil, start.Add(2*time.Minute))
+	require.Nil(ticked.Healthy.Status)
+	require.True(start.Add(2 * time.Minute).Equal(ticked.Healthy.LastTransitionTime.Time))
+
+	// and are ignored if optional
+	status = setCondition(status, ConditionMultistreaming, start, true)
+	status = data.NewMergedHealthStatus(status, data.HealthStatus{
+		Conditions: []*data.Condition{status.Conditions[0], unknownCondition(status.Conditions[1], start.Add(time.Minute))},
+	})
+	ticked, _ = tickReducer.ReduceTick(status, nil, start.Add(2*time.Minute))
+	require.Same(status, ticked)
+}
+
+func setCondition(status *data.HealthStatus, condType data.ConditionType, ts time.Time, value bool) *data.HealthStatus {
+	conditions := status.ConditionsCopy()
+	for i, cond := range conditions {
+		if cond.Type == condType {
+			conditions[i] = data.NewCondition(condType, ts, &value, cond)
+		}
+	}
+	return data.NewMergedHealthStatus(status, data.HealthStatus{Conditions: conditions})
+}
+
+func testEvent(ts time.Time) data.Event {
+	evt := data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: true})
+	evt.Timestamp_ = data.UnixMillisTime{Time: ts}
+	return evt
+}
@@ -0,0 +1,133 @@
+package reducers
+
+import (
+	"encoding/gob"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
+)
+
+const (
+	ConditionIngestBitrateStable   data.ConditionType = "IngestBitrateStable"
+	ConditionSegmentDurationNormal data.ConditionType = "SegmentDurationNormal"
+
+	MetricIngestBitrate   data.MetricName = "IngestBitrate"
+	MetricSegmentDuration data.MetricName = "SegmentDuration"
+
+	defaultMinBitrateRatio    = 0.5
+	defaultMaxSegmentDuration = 6
+	// bitrateAvgWeight is the weight of a new segment in the moving average of
+	// the bitrate, so a drop is only considered a collapse if it's sudden.
+	bitrateAvgWeight = 0.1
+	// bitrateWarmupSegments is how many segments are needed for the moving
+	// average to be a reliable baseline.
+	bitrateWarmupSegments = 5
+)
+
+var ingestConditions = []data.ConditionType{ConditionIngestBitrateStable, ConditionSegmentDurationNormal}
+
+// ingestState is the moving average of the ingest bitrate of each node, keyed
+// by the node ID.
+type ingestState struct {
+	Nodes map[string]*nodeIngest
+}
+
+type nodeIngest struct {
+	BitrateAvg float64
+	Segments   int
+}
+
+func init() {
+	gob.Register(&ingestState{})
+}
+
+// IngestReducer derives the bitrate and segment duration of the stream ingest
+// from the transcoded segments. Like the SegmentsReducer, it relies on the
+// bindings of the TranscodeReducer.
+type IngestReducer struct {
+	// MinBitrateRatio is the minimum ratio of the bitrate of a segment to the
+	// recent average for the IngestBitrateStable condition. Defaults to 0.5 if
+	// zero.
+	MinBitrateRatio float64
+	// MaxSegmentDuration is the maximum duration in seconds of a segment for the
+	// SegmentDurationNormal condition, since segments are cut on keyframes.
+	// Defaults to 6 if zero.
+	MaxSegmentDuration float64
+}
+
+func (t IngestReducer) Bindings() []event.BindingArgs {
+	return nil
+}
+
+func (t IngestReducer) Conditions() []data.ConditionType {
+	return ingestConditions
+}
+
+func (t IngestReducer) Reduce(current *data.HealthStatus, stateIface interface{}, evtIface data.Event) (*data.HealthStatus, interface{}) {
+	evt, ok := evtIface.(*data.TranscodeEvent)
+	if !ok || evt.Segment.Duration <= 0 {
+		return current, stateIface
+	}
+	var state *ingestState
+	if stateIface != nil {
+		state = stateIface.(*ingestState)
+	} else {
+		state = &ingestState{Nodes: map[string]*nodeIngest{}}
+	}
+	node, ok := state.Nodes[evt.NodeID]
+	if !ok {
+		node = &nodeIngest{}
+		state.Nodes[evt.NodeID] = node
+	}
+
+	duration := evt.Segment.Duration
+	bitrate := float64(evt.Segment.ByteSize*8) / duration
+	var bitrateStable *bool
+	if node.Segments >= bitrateWarmupSegments {
+		stable := bitrate >= t.minBitrateRatio()*node.BitrateAvg
+		bitrateStable = &stable
+	}
+	if node.Segments == 0 {
+		node.BitrateAvg = bitrate
+	} else {
+		node.BitrateAvg += bitrateAvgWeight * (bitrate - node.BitrateAvg)
+	}
+	node.Segments++
+	durationNormal := duration <= t.maxSegmentDuration()
+
+	ts := evt.Timestamp()
+	conditions := current.ConditionsCopy()
+	for i, cond := range conditions {
+		switch cond.Type {
+		case ConditionIngestBitrateStable:
+			if bitrateStable != nil {
+				conditions[i] = data.NewCondition(cond.Type, ts, bitrateStable, cond)
+			}
+		case ConditionSegmentDurationNormal:
+			conditions[i] = data.NewCondition(cond.Type, ts, &durationNormal, cond)
+		}
+	}
+	dimensions := map[string]string{"nodeId": evt.NodeID}
+	metrics := current.MetricsCopy().
+		Add(data.NewMetric(MetricIngestBitrate, dimensions, ts, bitrate)).
+		Add(data.NewMetric(MetricSegmentDuration, dimensions, ts, duration))
+
+	return data.NewMergedHealthStatus(current, data.HealthStatus{
+		Conditions: conditions,
+		Metrics:    metrics,
+	}), state
+}
+
+func (t IngestReducer) minBitrateRatio() float64 {
+	if t.MinBitrateRatio <= 0 {
+		return defaultMinBitrateRatio
+	}
+	return t.MinBitrateRatio
+}
+
+func (t IngestReducer) maxSegmentDuration() float64 {
+	if t.MaxSegmentDuration <= 0 {
+		return defaultMaxSegmentDuration
+	}
+	return t.MaxSegmentDuration
+}
@@ -0,0 +1,77 @@
+package reducers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngestReducerFirstSegment(t *testing.T) {
+	require := require.New(t)
+	reducer := IngestReducer{}
+	start := time.Now()
+	dims := map[string]string{"nodeId": "node"}
+
+	status, state := reducer.Reduce(newTestStatus(ingestConditions...), nil, ingestEvent(start, 1000, 2))
+	// there's no average to compare the bitrate with yet
+	require.Nil(status.Condition(ConditionIngestBitrateStable).Status)
+	require.True(*status.Condition(ConditionSegmentDurationNormal).Status)
+	require.Equal(4000.0, status.Metrics.GetMetric(MetricIngestBitrate, dims).Last.Value)
+	require.Equal(2.0, status.Metrics.GetMetric(MetricSegmentDuration, dims).Last.Value)
+
+	for i := 1; i < bitrateWarmupSegments; i++ {
+		status, state = reducer.Reduce(status, state, ingestEvent(start.Add(time.Duration(i)*time.Second), 1000, 2))
+		require.Nil(status.Condition(ConditionIngestBitrateStable).Status)
+	}
+	status, _ = reducer.Reduce(status, state, ingestEvent(start.Add(time.Minute), 1000, 2))
+	require.True(*status.Condition(ConditionIngestBitrateStable).Status)
+
+	// segments without a duration have no bitrate and are ignored
+	ignored, _ := reducer.Reduce(status, state, ingestEvent(start.Add(2*time.Minute), 1000, 0))
+	require.Same(status, ignored)
+}
+
+func TestIngestReducerThresholds(t *testing.T) {
+	tests := []struct {
+		name           string
+		reducer        IngestReducer
+		byteSize       int
+		duration       float64
+		bitrateStable  bool
+		durationNormal bool
+	}{
+		{name: "steady", byteSize: 1000, duration: 2, bitrateStable: true, durationNormal: true},
+		{name: "bitrate at min ratio", byteSize: 500, duration: 2, bitrateStable: true, durationNormal: true},
+		{name: "bitrate below min ratio", byteSize: 499, duration: 2, bitrateStable: false, durationNormal: true},
+		{name: "bitrate at custom min ratio", reducer: IngestReducer{MinBitrateRatio: 0.8}, byteSize: 800, duration: 2, bitrateStable: true, durationNormal: true},
+		{name: "bitrate below custom min ratio", reducer: IngestReducer{MinBitrateRatio: 0.8}, byteSize: 799, duration: 2, bitrateStable: false, durationNormal: true},
+		{name: "duration at max", byteSize: 3000, duration: 6, bitrateStable: true, durationNormal: true},
+		{name: "duration above max", byteSize: 3001, duration: 6.001, bitrateStable: true, durationNormal: false},
+		{name: "duration at custom max", reducer: IngestReducer{MaxSegmentDuration: 4}, byteSize: 2000, duration: 4, bitrateStable: true, durationNormal: true},
+		{name: "duration above custom max", reducer: IngestReducer{MaxSegmentDuration: 4}, byteSize: 2500, duration: 5, bitrateStable: true, durationNormal: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+			start := time.Now()
+			status := newTestStatus(ingestConditions...)
+			var state interface{}
+			// warm up the average with 4000 bits/s
+			for i := 0; i < bitrateWarmupSegments; i++ {
+				status, state = tt.reducer.Reduce(status, state, ingestEvent(start.Add(time.Duration(i)*time.Second), 1000, 2))
+			}
+
+			status, _ = tt.reducer.Reduce(status, state, ingestEvent(start.Add(time.Minute), tt.byteSize, tt.duration))
+			require.Equal(tt.bitrateStable, *status.Condition(ConditionIngestBitrateStable).Status)
+			require.Equal(tt.durationNormal, *status.Condition(ConditionSegmentDurationNormal).Status)
+		})
+	}
+}
+
+func ingestEvent(ts time.Time, byteSize int, duration float64) data.Event {
+	evt := data.NewTranscodeEvent("node", "stream-1", data.SegmentMetadata{Duration: duration, ByteSize: byteSize}, ts, true, nil)
+	evt.Timestamp_ = data.UnixMillisTime{Time: ts}
+	return evt
+}
@@ -10,6 +10,8 @@ import (
 
 const (
 	MetricViewerCount                data.MetricName = "ViewerCount"
+	MetricViewerSessionCount         data.MetricName = "ViewerSessionCount"
+	MetricTotalViewerCount           data.MetricName = "TotalViewerCount"
 	MetricMediaTimeMillis            data.MetricName = "MediaTimeMillis"
 	MetricMultistreamMediaTimeMillis data.MetricName = "MultistreamMediaTimeMillis"
 	MetricMultistreamActiveSec       data.MetricName = "MultistreamActiveSec"
@@ -18,6 +20,10 @@ const (
 
 	mediaServerExchange = "lp_mist_api_connector"
 	metricsBindingKey   = "stream.metrics.#"
+
+	// viewerCountStaleness is how long until the viewer count of a node is
+	// ignored in the total, since nodes stop sending it when the stream ends.
+	viewerCountStaleness = 5 * time.Minute
 )
 
 type MediaServerMetrics struct{}
@@ -38,8 +44,16 @@ func (t MediaServerMetrics) Reduce(current *data.HealthStatus, _ interface{}, ev
 
 	metrics := current.MetricsCopy()
 	ts, dims := evt.Timestamp(), map[string]string{"region": evt.Region, "nodeId": evt.NodeID}
-	if evt.Stats != nil && evt.Stats.MediaTimeMs != nil {
-		metrics.Add(data.NewMetric(MetricMediaTimeMillis, dims, ts, float64(*evt.Stats.MediaTimeMs)))
+	if evt.Stats != nil {
+		if evt.Stats.MediaTimeMs != nil {
+			metrics.Add(data.NewMetric(MetricMediaTimeMillis, dims, ts, float64(*evt.Stats.MediaTimeMs)))
+		}
+		if evt.Stats.ViewerCount != nil {
+			metrics.Add(data.NewMetric(MetricViewerCount, dims, ts, float64(*evt.Stats.ViewerCount)))
+		}
+		if evt.Stats.SessionCount != nil {
+			metrics.Add(data.NewMetric(MetricViewerSessionCount, dims, ts, float64(*evt.Stats.SessionCount)))
+		}
 	}
 	for _, ms := range evt.Multistream {
 		for _, metric := range multistreamMetrics(current, ts, evt.NodeID, evt.Region, ms) {
@@ -47,9 +61,13 @@ func (t MediaServerMetrics) Reduce(current *data.HealthStatus, _ interface{}, ev
 		}
 	}
 
-	if vc := totalViewerCount(metrics); vc > 10 {
+	vc := totalViewerCount(metrics, ts)
+	if vc > 10 {
 		glog.Warningf("High viewer count stream! streamId=%q viewerCount=%d", evt.StreamID(), vc)
 	}
+	if len(metrics[MetricViewerCount]) > 0 {
+		metrics.Add(data.NewMetric(MetricTotalViewerCount, nil, ts, float64(vc)))
+	}
 	return data.NewMergedHealthStatus(current, data.HealthStatus{Metrics: metrics}), nil
 }
 
@@ -70,17 +88,30 @@ func multistreamMetrics(current *data.HealthStatus, ts time.Time, nodeID, region
 		data.NewMetric(MetricMultistreamBytes, msDims, ts, float64(ms.Metrics.Bytes)),
 	}
 	if prevBytes := current.Metrics.GetMetric(MetricMultistreamBytes, msDims); prevBytes != nil {
-		prevTs, prevVal := prevBytes.Last.Timestamp, int64(prevBytes.Last.Value)
-		bitrate := float64(ms.Metrics.Bytes-prevVal) / float64(ts.Sub(prevTs)/time.Second)
-		metrics = append(metrics, data.NewMetric(MetricMultistreamBitrateSec, msDims, ts, bitrate))
+		if bitrate, ok := byteRate(prevBytes.Last, ms.Metrics.Bytes, ts); ok {
+			metrics = append(metrics, data.NewMetric(MetricMultistreamBitrateSec, msDims, ts, bitrate))
+		}
 	}
 	return metrics
 }
 
-func totalViewerCount(metrics data.MetricsMap) int {
+// byteRate returns the bytes per second from the previous measure of a byte
+// counter. There's no rate if no time has passed, e.g. with out of order or
+// duplicate events, or if the counter went backwards because it restarted.
+func byteRate(prev data.Measure, bytes int64, ts time.Time) (float64, bool) {
+	elapsed := ts.Sub(prev.Timestamp).Seconds()
+	delta := float64(bytes) - prev.Value
+	if elapsed <= 0 || delta < 0 {
+		return 0, false
+	}
+	return delta / elapsed, true
+}
+
+// totalViewerCount sums the viewer count of all nodes, as of the given time.
+func totalViewerCount(metrics data.MetricsMap, now time.Time) int {
 	total := 0.0
 	for _, metric := range metrics[MetricViewerCount] {
-		if time.Since(metric.Last.Timestamp) > 5*time.Minute {
+		if now.Sub(metric.Last.Timestamp) > viewerCountStaleness {
 			// ignore stale metrics from potentially old sessions.
 			continue
 		}
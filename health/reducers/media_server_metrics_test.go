@@ -0,0 +1,55 @@
+package reducers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaServerMetricsViewerCount(t *testing.T) {
+	require := require.New(t)
+	reducer := MediaServerMetrics{}
+	start := time.Now()
+	nodeDims := func(nodeID string) map[string]string {
+		return map[string]string{"region": "region", "nodeId": nodeID}
+	}
+	totalViewerCount := func(status *data.HealthStatus) float64 {
+		return status.Metrics.GetMetric(MetricTotalViewerCount, nil).Last.Value
+	}
+
+	// metrics without viewer counts have no total
+	status, _ := reducer.Reduce(newTestStatus(), nil, viewersEvent("node-1", start, nil))
+	require.Empty(status.Metrics[MetricViewerCount])
+	require.Empty(status.Metrics[MetricTotalViewerCount])
+
+	// single node
+	viewers := int64(3)
+	status, _ = reducer.Reduce(status, nil, viewersEvent("node-1", start, &viewers))
+	require.Equal(3.0, status.Metrics.GetMetric(MetricViewerCount, nodeDims("node-1")).Last.Value)
+	require.Equal(3.0, totalViewerCount(status))
+
+	// several nodes are summed
+	viewers = 5
+	status, _ = reducer.Reduce(status, nil, viewersEvent("node-2", start.Add(time.Minute), &viewers))
+	require.Equal(5.0, status.Metrics.GetMetric(MetricViewerCount, nodeDims("node-2")).Last.Value)
+	require.Equal(8.0, totalViewerCount(status))
+	viewers = 1
+	status, _ = reducer.Reduce(status, nil, viewersEvent("node-1", start.Add(2*time.Minute), &viewers))
+	require.Equal(6.0, totalViewerCount(status))
+
+	// stale nodes are ignored in the total
+	viewers = 2
+	status, _ = reducer.Reduce(status, nil, viewersEvent("node-2", start.Add(2*time.Minute+viewerCountStaleness), &viewers))
+	require.Equal(3.0, totalViewerCount(status))
+	status, _ = reducer.Reduce(status, nil, viewersEvent("node-2", start.Add(3*time.Minute+viewerCountStaleness), &viewers))
+	require.Equal(2.0, totalViewerCount(status))
+	require.Equal(1.0, status.Metrics.GetMetric(MetricViewerCount, nodeDims("node-1")).Last.Value)
+}
+
+func viewersEvent(nodeID string, ts time.Time, viewerCount *int64) data.Event {
+	evt := data.NewMediaServerMetricsEvent(nodeID, "region", "stream-1", &data.StreamMetrics{ViewerCount: viewerCount}, nil)
+	evt.Timestamp_ = data.UnixMillisTime{Time: ts}
+	return evt
+}
@@ -1,6 +1,7 @@
 package reducers
 
 import (
+	"encoding/gob"
 	"encoding/json"
 	"strings"
 	"time"
@@ -15,9 +16,34 @@ const (
 
 	webhooksExchange      = "webhook_default_exchange"
 	multistreamBindingKey = "events.multistream.#"
+
+	defaultMultistreamStallTimeout = 30 * time.Second
 )
 
-type MultistreamReducer struct{}
+// multistreamState is the last progress of each multistream target, keyed by
+// targetKey.
+type multistreamState struct {
+	Targets map[string]*targetProgress
+}
+
+type targetProgress struct {
+	Bytes, MediaTimeMs int64
+	LastProgress       time.Time
+}
+
+func init() {
+	gob.Register(&multistreamState{})
+}
+
+// MultistreamReducer tracks the status of the multistream targets from their
+// webhooks. It also detects stalled targets from the media server metrics,
+// relying on the bindings of the MediaServerMetrics reducer for those.
+type MultistreamReducer struct {
+	// StallTimeout is how long a connected target can go without progress in
+	// bytes or media time before being considered stalled. Defaults to 30s if
+	// zero.
+	StallTimeout time.Duration
+}
 
 func (t MultistreamReducer) Bindings() []event.BindingArgs {
 	return []event.BindingArgs{{Exchange: webhooksExchange, Key: multistreamBindingKey}}
@@ -27,26 +53,43 @@ func (t MultistreamReducer) Conditions() []data.ConditionType {
 	return []data.ConditionType{ConditionMultistreaming}
 }
 
-func (t MultistreamReducer) Reduce(current *data.HealthStatus, _ interface{}, evtIface data.Event) (*data.HealthStatus, interface{}) {
-	evt, ok := evtIface.(*data.WebhookEvent)
-	if !ok {
-		return current, nil
+func (t MultistreamReducer) Reduce(current *data.HealthStatus, stateIface interface{}, evtIface data.Event) (*data.HealthStatus, interface{}) {
+	var state *multistreamState
+	if stateIface != nil {
+		state = stateIface.(*multistreamState)
+	} else {
+		state = &multistreamState{Targets: map[string]*targetProgress{}}
+	}
+
+	switch evt := evtIface.(type) {
+	case *data.WebhookEvent:
+		return reduceMultistreamWebhook(current, state, evt), state
+	case *data.MediaServerMetricsEvent:
+		return reduceMultistreamStalls(current, state, evt, t.stallTimeout()), state
+	default:
+		return current, state
 	}
+}
+
+func reduceMultistreamWebhook(current *data.HealthStatus, state *multistreamState, evt *data.WebhookEvent) *data.HealthStatus {
 	if !strings.HasPrefix(evt.Event, "multistream.") {
-		return current, nil
+		return current
 	}
 
 	ts := evt.Timestamp()
 	var payload data.MultistreamWebhookPayload
 	if err := json.Unmarshal(evt.Payload, &payload); err != nil {
 		glog.Errorf("Error parsing multistream webhook payload. err=%q", err)
-		return current, nil
+		return current
 	}
 	target := payload.Target
 
 	multistream := current.MultistreamCopy()
 	multistream, idx := findOrCreateMultistreamStatus(multistream, target)
 	currConnected := multistream[idx].Connected
+	// Every webhook replaces the status of the target, so the stall detection
+	// starts over.
+	delete(state.Targets, targetKey(target))
 
 	switch evt.Event {
 	case "multistream.connected":
@@ -67,39 +110,115 @@ func (t MultistreamReducer) Reduce(current *data.HealthStatus, _ interface{}, ev
 		glog.Errorf("Unknown multistream webhook event. event=%q", evt.Event)
 	}
 
+	return data.NewMergedHealthStatus(current, data.HealthStatus{
+		Conditions:  multistreamingConditions(current, multistream, ts),
+		Multistream: multistream,
+	})
+}
+
+func (t MultistreamReducer) stallTimeout() time.Duration {
+	if t.StallTimeout <= 0 {
+		return defaultMultistreamStallTimeout
+	}
+	return t.StallTimeout
+}
+
+// reduceMultistreamStalls updates the Stalled condition of the connected
+// targets from their metrics. A target is stalled if neither its bytes nor its
+// media time grew for longer than the stall timeout. Counters going backwards
+// are considered a restart of the target and reset the progress.
+func reduceMultistreamStalls(current *data.HealthStatus, state *multistreamState, evt *data.MediaServerMetricsEvent, stallTimeout time.Duration) *data.HealthStatus {
+	ts := evt.Timestamp()
+	var multistream []*data.MultistreamStatus
+	for _, ms := range evt.Multistream {
+		if ms == nil || ms.Metrics == nil {
+			continue
+		}
+		idx := findMultistreamStatus(current.Multistream, ms.Target)
+		if idx < 0 || !isConnected(current.Multistream[idx]) {
+			// disconnected targets can't stall, and reconnecting them resets the
+			// progress anyway
+			continue
+		}
+		key := targetKey(ms.Target)
+		progress, ok := state.Targets[key]
+		if !ok {
+			state.Targets[key] = &targetProgress{Bytes: ms.Metrics.Bytes, MediaTimeMs: ms.Metrics.MediaTimeMs, LastProgress: ts}
+			continue
+		}
+		if ms.Metrics.Bytes != progress.Bytes || ms.Metrics.MediaTimeMs != progress.MediaTimeMs {
+			progress.Bytes, progress.MediaTimeMs, progress.LastProgress = ms.Metrics.Bytes, ms.Metrics.MediaTimeMs, ts
+		}
+		stalled := ts.Sub(progress.LastProgress) > stallTimeout
+
+		if multistream == nil {
+			multistream = current.MultistreamCopy()
+		}
+		curr := multistream[idx]
+		if curr.Stalled != nil && curr.Stalled.Status != nil && *curr.Stalled.Status != stalled {
+			glog.Infof("Multistream target stall status changed. streamId=%q targetId=%q profile=%q stalled=%v", evt.StreamID(), ms.Target.ID, ms.Target.Profile, stalled)
+		}
+		multistream[idx] = &data.MultistreamStatus{
+			Target:    curr.Target,
+			Connected: curr.Connected,
+			Stalled:   data.NewCondition("", ts, &stalled, curr.Stalled),
+		}
+	}
+	if multistream == nil {
+		return current
+	}
+
+	return data.NewMergedHealthStatus(current, data.HealthStatus{
+		Conditions:  multistreamingConditions(current, multistream, ts),
+		Multistream: multistream,
+	})
+}
+
+func multistreamingConditions(current *data.HealthStatus, multistream []*data.MultistreamStatus, ts time.Time) []*data.Condition {
 	conditions := current.ConditionsCopy()
 	for i, cond := range conditions {
 		if cond.Type == ConditionMultistreaming {
 			if len(multistream) == 0 {
 				conditions[i] = &data.Condition{Type: ConditionMultistreaming}
 			} else {
-				status := allTargetsConnected(multistream)
+				status := allTargetsHealthy(multistream)
 				conditions[i] = data.NewCondition(cond.Type, ts, &status, cond)
 			}
 		}
 	}
-
-	return data.NewMergedHealthStatus(current, data.HealthStatus{
-		Conditions:  conditions,
-		Multistream: multistream,
-	}), nil
+	return conditions
 }
 
-func allTargetsConnected(multistream []*data.MultistreamStatus) bool {
+// allTargetsHealthy returns whether all targets are connected and not stalled.
+func allTargetsHealthy(multistream []*data.MultistreamStatus) bool {
 	for _, ms := range multistream {
-		if ms.Connected.Status == nil || !*ms.Connected.Status {
+		if !isConnected(ms) {
+			return false
+		}
+		if ms.Stalled != nil && ms.Stalled.Status != nil && *ms.Stalled.Status {
 			return false
 		}
 	}
 	return true
 }
 
-func findOrCreateMultistreamStatus(multistream []*data.MultistreamStatus, target data.MultistreamTargetInfo) ([]*data.MultistreamStatus, int) {
+func isConnected(ms *data.MultistreamStatus) bool {
+	return ms.Connected != nil && ms.Connected.Status != nil && *ms.Connected.Status
+}
+
+func findMultistreamStatus(multistream []*data.MultistreamStatus, target data.MultistreamTargetInfo) int {
 	for idx, ms := range multistream {
 		if targetsEq(ms.Target, target) {
-			return multistream, idx
+			return idx
 		}
 	}
+	return -1
+}
+
+func findOrCreateMultistreamStatus(multistream []*data.MultistreamStatus, target data.MultistreamTargetInfo) ([]*data.MultistreamStatus, int) {
+	if idx := findMultistreamStatus(multistream, target); idx >= 0 {
+		return multistream, idx
+	}
 
 	multistream = append(multistream, &data.MultistreamStatus{
 		Target:    target,
@@ -111,3 +230,7 @@ func findOrCreateMultistreamStatus(multistream []*data.MultistreamStatus, target
 func targetsEq(t1, t2 data.MultistreamTargetInfo) bool {
 	return t1.Profile == t2.Profile && t1.ID == t2.ID
 }
+
+func targetKey(target data.MultistreamTargetInfo) string {
+	return target.ID + "/" + target.Profile
+}
@@ -0,0 +1,145 @@
+package reducers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/stats"
+	"github.com/stretchr/testify/require"
+)
+
+var testTarget = data.MultistreamTargetInfo{ID: "target-1", Name: "Target", Profile: "720p"}
+
+func TestMultistreamReducerStalls(t *testing.T) {
+	require := require.New(t)
+	reducer := MultistreamReducer{StallTimeout: 10 * time.Second}
+	start := time.Now()
+
+	status, state := reducer.Reduce(newTestStatus(ConditionMultistreaming), nil, multistreamWebhook(t, "multistream.connected", start))
+	require.True(*status.Condition(ConditionMultistreaming).Status)
+	require.Len(status.Multistream, 1)
+	require.True(*status.Multistream[0].Connected.Status)
+	require.Nil(status.Multistream[0].Stalled)
+
+	// progress keeps the target healthy
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start, 1000, 1000))
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(8*time.Second), 2000, 2000))
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(16*time.Second), 2000, 2000))
+	require.False(*status.Multistream[0].Stalled.Status)
+	require.True(*status.Condition(ConditionMultistreaming).Status)
+
+	// no progress for longer than the timeout stalls it
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(19*time.Second), 2000, 2000))
+	require.True(*status.Multistream[0].Stalled.Status)
+	require.False(*status.Condition(ConditionMultistreaming).Status)
+
+	// and progress again recovers it
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(20*time.Second), 3000, 3000))
+	require.False(*status.Multistream[0].Stalled.Status)
+	require.True(*status.Condition(ConditionMultistreaming).Status)
+
+	// reconnecting resets the stall detection
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(40*time.Second), 3000, 3000))
+	require.True(*status.Multistream[0].Stalled.Status)
+	status, state = reducer.Reduce(status, state, multistreamWebhook(t, "multistream.connected", start.Add(41*time.Second)))
+	require.Nil(status.Multistream[0].Stalled)
+	require.True(*status.Condition(ConditionMultistreaming).Status)
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(42*time.Second), 0, 0))
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(50*time.Second), 0, 0))
+	require.False(*status.Multistream[0].Stalled.Status)
+	require.True(*status.Condition(ConditionMultistreaming).Status)
+
+	// disconnecting removes the target
+	status, _ = reducer.Reduce(status, state, multistreamWebhook(t, "multistream.disconnected", start.Add(51*time.Second)))
+	require.Empty(status.Multistream)
+	require.Nil(status.Condition(ConditionMultistreaming).Status)
+}
+
+func TestMultistreamReducerIgnoresStallsWhenNotConnected(t *testing.T) {
+	require := require.New(t)
+	reducer := MultistreamReducer{StallTimeout: 10 * time.Second}
+	start := time.Now()
+
+	status, state := reducer.Reduce(newTestStatus(ConditionMultistreaming), nil, multistreamWebhook(t, "multistream.error", start))
+	require.False(*status.Multistream[0].Connected.Status)
+	require.False(*status.Condition(ConditionMultistreaming).Status)
+
+	errored := status
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(time.Second), 1000, 1000))
+	status, _ = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(time.Minute), 1000, 1000))
+	require.Same(errored, status)
+	require.Nil(status.Multistream[0].Stalled)
+
+	// metrics of unknown targets are ignored as well
+	status = newTestStatus(ConditionMultistreaming)
+	ignored, _ := reducer.Reduce(status, nil, multistreamMetricsEvent(start, 1000, 1000))
+	require.Same(status, ignored)
+}
+
+func TestMultistreamReducerDefaultStallTimeout(t *testing.T) {
+	require := require.New(t)
+	reducer := MultistreamReducer{}
+	start := time.Now()
+
+	status, state := reducer.Reduce(newTestStatus(ConditionMultistreaming), nil, multistreamWebhook(t, "multistream.connected", start))
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start, 1000, 1000))
+	status, state = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(defaultMultistreamStallTimeout), 1000, 1000))
+	require.False(*status.Multistream[0].Stalled.Status)
+	status, _ = reducer.Reduce(status, state, multistreamMetricsEvent(start.Add(defaultMultistreamStallTimeout+time.Second), 1000, 1000))
+	require.True(*status.Multistream[0].Stalled.Status)
+}
+
+func TestMultistreamBitrate(t *testing.T) {
+	require := require.New(t)
+	window := stats.Window{Duration: time.Minute}
+	metricsReducer, statsReducer := MediaServerMetrics{}, StatsReducer([]time.Duration{window.Duration}, 0)
+	dims := map[string]string{
+		"nodeId":        "node",
+		"region":        "region",
+		"targetId":      testTarget.ID,
+		"targetName":    testTarget.Name,
+		"targetProfile": testTarget.Profile,
+	}
+	status, start := newTestStatus(), time.Now()
+	var statsState interface{}
+	reduce := func(offset time.Duration, bytes int64) *data.Metric {
+		evt := multistreamMetricsEvent(start.Add(offset), bytes, 0)
+		status, _ = metricsReducer.Reduce(status, nil, evt)
+		status, statsState = statsReducer(status, statsState, evt)
+		return status.Metrics.GetMetric(MetricMultistreamBitrateSec, dims)
+	}
+
+	// the first measure has no previous one for a rate
+	require.Nil(reduce(0, 0))
+
+	require.Equal(1000.0, reduce(2*time.Second, 2000).Last.Value)
+	require.Equal(2000.0, reduce(4*time.Second, 6000).Last.Value)
+	bitrate := reduce(8*time.Second, 10000)
+	require.Equal(1000.0, bitrate.Last.Value)
+	summary := bitrate.Stats[window]
+	require.Equal(1000.0, summary.Min)
+	require.Equal(2000.0, summary.Max)
+	require.InDelta(4000.0/3, summary.Avg, 1e-9)
+
+	// duplicate events and restarted counters don't produce a rate
+	require.Equal(bitrate.Last, reduce(8*time.Second, 10000).Last)
+	require.Equal(bitrate.Last, reduce(10*time.Second, 0).Last)
+	require.Equal(0.0, status.Metrics.GetMetric(MetricMultistreamBytes, dims).Last.Value)
+}
+
+func multistreamWebhook(t *testing.T, event string, ts time.Time) data.Event {
+	evt, err := data.NewWebhookEvent("stream-1", event, "user", "session", data.MultistreamWebhookPayload{Target: testTarget})
+	require.NoError(t, err)
+	evt.Timestamp_ = data.UnixMillisTime{Time: ts}
+	return evt
+}
+
+func multistreamMetricsEvent(ts time.Time, bytes, mediaTimeMs int64) data.Event {
+	evt := data.NewMediaServerMetricsEvent("node", "region", "stream-1", nil, []*data.MultistreamTargetMetrics{{
+		Target:  testTarget,
+		Metrics: &data.MultistreamMetrics{Bytes: bytes, MediaTimeMs: mediaTimeMs},
+	}})
+	evt.Timestamp_ = data.UnixMillisTime{Time: ts}
+	return evt
+}
@@ -1,6 +1,9 @@
 package reducers
 
 import (
+	"encoding/gob"
+	"time"
+
 	"github.com/golang/glog"
 	"github.com/livepeer/livepeer-data/health"
 	"github.com/livepeer/livepeer-data/pkg/data"
@@ -9,6 +12,10 @@ import (
 
 type Pipeline []health.Reducer
 
+func init() {
+	gob.Register([]interface{}{})
+}
+
 func (p Pipeline) Bindings() []event.BindingArgs {
 	added := map[string]bool{}
 	bindings := []event.BindingArgs{}
@@ -43,14 +50,33 @@ func (p Pipeline) Conditions() []data.ConditionType {
 }
 
 func (p Pipeline) Reduce(current *data.HealthStatus, stateIface interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
-	var state []interface{}
-	if stateIface != nil {
-		state = stateIface.([]interface{})
-	} else {
-		state = make([]interface{}, len(p))
-	}
+	state := p.state(stateIface)
 	for i, reducer := range p {
 		current, state[i] = reducer.Reduce(current, state[i], evt)
 	}
 	return current, state
 }
+
+// ReduceTick calls ReduceTick on the reducers of the pipeline that implement
+// health.TickReducer, in order.
+func (p Pipeline) ReduceTick(current *data.HealthStatus, stateIface interface{}, now time.Time) (*data.HealthStatus, interface{}) {
+	state := p.state(stateIface)
+	for i, reducer := range p {
+		if tickReducer, ok := reducer.(health.TickReducer); ok {
+			current, state[i] = tickReducer.ReduceTick(current, state[i], now)
+		}
+	}
+	return current, state
+}
+
+// state returns the state of each reducer of the pipeline. The state is reset
+// if it doesn't match the pipeline, like when restoring persisted records after
+// the pipeline config changed.
+func (p Pipeline) state(stateIface interface{}) []interface{} {
+	if state, ok := stateIface.([]interface{}); ok && len(state) == len(p) {
+		return state
+	} else if stateIface != nil {
+		glog.Warningf("Resetting reducer state not matching the pipeline. reducers=%d", len(p))
+	}
+	return make([]interface{}, len(p))
+}
@@ -0,0 +1,108 @@
+package reducers
+
+import (
+	"encoding/gob"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
+)
+
+const (
+	ConditionSegmentsContinuous data.ConditionType = "SegmentsContinuous"
+
+	MetricSegmentGapCount        data.MetricName = "SegmentGapCount"
+	MetricSegmentDuplicateCount  data.MetricName = "SegmentDuplicateCount"
+	MetricSegmentOutOfOrderCount data.MetricName = "SegmentOutOfOrderCount"
+)
+
+// segmentsState is the sequence tracking of the segments transcoded by each
+// node, keyed by the node ID.
+type segmentsState struct {
+	Nodes map[string]*nodeSegments
+}
+
+type nodeSegments struct {
+	LastSeqNo uint64
+	// Missing is the count of sequence numbers skipped by the node. Duplicate
+	// and OutOfOrder are the count of segments with a sequence number not
+	// greater than the last one.
+	Missing, Duplicate, OutOfOrder uint64
+}
+
+func init() {
+	gob.Register(&segmentsState{})
+}
+
+// SegmentsReducer checks the continuity of the sequence numbers of the
+// transcoded segments. It has no bindings of its own since it consumes the
+// same events as the TranscodeReducer, which must be in the pipeline as well.
+type SegmentsReducer struct{}
+
+func (t SegmentsReducer) Bindings() []event.BindingArgs {
+	return nil
+}
+
+func (t SegmentsReducer) Conditions() []data.ConditionType {
+	return []data.ConditionType{ConditionSegmentsContinuous}
+}
+
+func (t SegmentsReducer) Reduce(current *data.HealthStatus, stateIface interface{}, evtIface data.Event) (*data.HealthStatus, interface{}) {
+	evt, ok := evtIface.(*data.TranscodeEvent)
+	if !ok {
+		return current, stateIface
+	}
+	var state *segmentsState
+	if stateIface != nil {
+		state = stateIface.(*segmentsState)
+	} else {
+		state = &segmentsState{Nodes: map[string]*nodeSegments{}}
+	}
+
+	// duplicates are usually retries of the last segment, so they don't affect
+	// the continuity and the condition is kept as is.
+	continuous, duplicate := true, false
+	seqNo, node := evt.Segment.SeqNo, state.Nodes[evt.NodeID]
+	switch {
+	case node == nil:
+		node = &nodeSegments{LastSeqNo: seqNo}
+		state.Nodes[evt.NodeID] = node
+	case seqNo == 0 && node.LastSeqNo > 0:
+		// Sequence numbers restart from 0 when the broadcaster restarts the stream.
+		glog.Infof("Segment sequence restarted. streamId=%q nodeId=%q lastSeqNo=%d", evt.StreamID(), evt.NodeID, node.LastSeqNo)
+		node.LastSeqNo = seqNo
+	case seqNo == node.LastSeqNo:
+		duplicate = true
+		node.Duplicate++
+		glog.V(4).Infof("Duplicate segment. streamId=%q nodeId=%q seqNo=%d", evt.StreamID(), evt.NodeID, seqNo)
+	case seqNo < node.LastSeqNo:
+		continuous = false
+		node.OutOfOrder++
+		glog.V(4).Infof("Out of order segment. streamId=%q nodeId=%q seqNo=%d lastSeqNo=%d", evt.StreamID(), evt.NodeID, seqNo, node.LastSeqNo)
+	default:
+		if missing := seqNo - node.LastSeqNo - 1; missing > 0 {
+			continuous = false
+			node.Missing += missing
+			glog.V(4).Infof("Missing segments. streamId=%q nodeId=%q seqNo=%d lastSeqNo=%d missing=%d", evt.StreamID(), evt.NodeID, seqNo, node.LastSeqNo, missing)
+		}
+		node.LastSeqNo = seqNo
+	}
+
+	ts := evt.Timestamp()
+	conditions := current.ConditionsCopy()
+	for i, cond := range conditions {
+		if cond.Type == ConditionSegmentsContinuous && !duplicate {
+			conditions[i] = data.NewCondition(cond.Type, ts, &continuous, cond)
+		}
+	}
+	dimensions := map[string]string{"nodeId": evt.NodeID}
+	metrics := current.MetricsCopy().
+		Add(data.NewMetric(MetricSegmentGapCount, dimensions, ts, float64(node.Missing))).
+		Add(data.NewMetric(MetricSegmentDuplicateCount, dimensions, ts, float64(node.Duplicate))).
+		Add(data.NewMetric(MetricSegmentOutOfOrderCount, dimensions, ts, float64(node.OutOfOrder)))
+
+	return data.NewMergedHealthStatus(current, data.HealthStatus{
+		Conditions: conditions,
+		Metrics:    metrics,
+	}), state
+}
@@ -0,0 +1,77 @@
+package reducers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentsReducer(t *testing.T) {
+	start := time.Now()
+	dims := map[string]string{"nodeId": "node"}
+
+	tests := []struct {
+		name       string
+		seqNos     []uint64
+		continuous bool
+		gaps, dups uint64
+		outOfOrder uint64
+	}{
+		{name: "in order", seqNos: []uint64{0, 1, 2, 3}, continuous: true},
+		{name: "gap", seqNos: []uint64{0, 1, 4}, continuous: false, gaps: 2},
+		{name: "gap recovered", seqNos: []uint64{0, 1, 4, 5}, continuous: true, gaps: 2},
+		{name: "duplicate", seqNos: []uint64{0, 1, 1, 2}, continuous: true, dups: 1},
+		{name: "duplicate keeps status", seqNos: []uint64{0, 2, 2}, continuous: false, gaps: 1, dups: 1},
+		{name: "out of order", seqNos: []uint64{0, 1, 3, 2}, continuous: false, gaps: 1, outOfOrder: 1},
+		{name: "restart", seqNos: []uint64{5, 6, 0, 1}, continuous: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require := require.New(t)
+			reducer := SegmentsReducer{}
+			status := newTestStatus(ConditionSegmentsContinuous)
+			var state interface{}
+			for i, seqNo := range tt.seqNos {
+				status, state = reducer.Reduce(status, state, transcodeEvent(seqNo, start.Add(time.Duration(i)*time.Second)))
+			}
+
+			require.Equal(tt.continuous, *status.Condition(ConditionSegmentsContinuous).Status)
+			require.Equal(float64(tt.gaps), status.Metrics.GetMetric(MetricSegmentGapCount, dims).Last.Value)
+			require.Equal(float64(tt.dups), status.Metrics.GetMetric(MetricSegmentDuplicateCount, dims).Last.Value)
+			require.Equal(float64(tt.outOfOrder), status.Metrics.GetMetric(MetricSegmentOutOfOrderCount, dims).Last.Value)
+		})
+	}
+}
+
+func TestSegmentsReducerDuplicateIsNotProbe(t *testing.T) {
+	require := require.New(t)
+	reducer := SegmentsReducer{}
+	start := time.Now()
+
+	status, state := reducer.Reduce(newTestStatus(ConditionSegmentsContinuous), nil, transcodeEvent(1, start))
+	status, state = reducer.Reduce(status, state, transcodeEvent(1, start.Add(time.Second)))
+	cond := status.Condition(ConditionSegmentsContinuous)
+	require.True(*cond.Status)
+	require.True(start.Equal(cond.LastProbeTime.Time))
+
+	// each node has its own sequence
+	status, _ = reducer.Reduce(status, state, data.NewTranscodeEvent("other-node", "stream-1", data.SegmentMetadata{SeqNo: 7}, start, true, nil))
+	require.True(*status.Condition(ConditionSegmentsContinuous).Status)
+	require.Len(status.Metrics[MetricSegmentGapCount], 2)
+}
+
+func transcodeEvent(seqNo uint64, ts time.Time) data.Event {
+	evt := data.NewTranscodeEvent("node", "stream-1", data.SegmentMetadata{SeqNo: seqNo, Duration: 2}, ts, true, nil)
+	evt.Timestamp_ = data.UnixMillisTime{Time: ts}
+	return evt
+}
+
+func newTestStatus(condTypes ...data.ConditionType) *data.HealthStatus {
+	conditions := make([]*data.Condition, len(condTypes))
+	for i, condType := range condTypes {
+		conditions[i] = data.NewCondition(condType, time.Time{}, nil, nil)
+	}
+	return data.NewHealthStatus("stream-1", conditions)
+}
@@ -1,6 +1,7 @@
 package reducers
 
 import (
+	"encoding/gob"
 	"time"
 
 	"github.com/livepeer/livepeer-data/health"
@@ -11,39 +12,92 @@ import (
 type statsAggrs struct {
 	HealthStats    stats.WindowAggregators
 	ConditionStats map[data.ConditionType]stats.WindowAggregators
+	// MetricStats are keyed by data.Metric.Key so there's one series for each
+	// set of dimensions of a metric.
+	MetricStats map[string]stats.WindowAggregators
 }
 
-func StatsReducer(statsWindows []time.Duration) health.ReducerFunc {
+func init() {
+	gob.Register(&statsAggrs{})
+}
+
+// StatsReducer computes the windowed stats of the conditions and metrics. The
+// measures are aggregated in buckets of the given resolution to bound memory
+// usage, or kept individually for exact stats if resolution is not positive.
+func StatsReducer(statsWindows []time.Duration, resolution time.Duration) health.ReducerFunc {
+	newAggrs := func() stats.WindowAggregators {
+		return stats.NewWindowAggregators(statsWindows, resolution)
+	}
+
 	return func(current *data.HealthStatus, stateIface interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
 		var state *statsAggrs
 		if stateIface != nil {
 			state = stateIface.(*statsAggrs)
 		} else {
 			state = &statsAggrs{
-				HealthStats:    stats.WindowAggregators{},
+				HealthStats:    newAggrs(),
 				ConditionStats: map[data.ConditionType]stats.WindowAggregators{},
 			}
 		}
+		if state.MetricStats == nil {
+			state.MetricStats = map[string]stats.WindowAggregators{}
+		}
 
 		ts := evt.Timestamp()
 		conditions := current.ConditionsCopy()
 		for i, cond := range conditions {
 			statsAggr, ok := state.ConditionStats[cond.Type]
 			if !ok {
-				statsAggr = stats.WindowAggregators{}
+				statsAggr = newAggrs()
 				state.ConditionStats[cond.Type] = statsAggr
 			}
 			conditions[i] = reduceCondStats(cond, ts, statsAggr, statsWindows)
 		}
 		healthy := reduceCondStats(current.Healthy, ts, state.HealthStats, statsWindows)
+		metrics := reduceMetricsStats(current, ts, state.MetricStats, newAggrs, statsWindows)
 
 		return data.NewMergedHealthStatus(current, data.HealthStatus{
 			Healthy:    healthy,
 			Conditions: conditions,
+			Metrics:    metrics,
 		}), state
 	}
 }
 
+// reduceMetricsStats updates the stats of the metrics measured on the given
+// timestamp, returning nil if there were none. The series of metrics that are
+// not in the status anymore are dropped.
+func reduceMetricsStats(current *data.HealthStatus, ts time.Time, metricsAggrs map[string]stats.WindowAggregators, newAggrs func() stats.WindowAggregators, statsWindows []time.Duration) data.MetricsMap {
+	var updated data.MetricsMap
+	existing := make(map[string]bool, len(metricsAggrs))
+	for _, metrics := range current.Metrics {
+		for _, metric := range metrics {
+			key := metric.Key()
+			existing[key] = true
+			if !metric.Last.Timestamp.Equal(ts) {
+				continue
+			}
+			aggrs, ok := metricsAggrs[key]
+			if !ok {
+				aggrs = newAggrs()
+				metricsAggrs[key] = aggrs
+			}
+			if updated == nil {
+				updated = current.MetricsCopy()
+			}
+			newMetric := *metric
+			newMetric.Stats = aggrs.Summaries(statsWindows, ts, &metric.Last.Value)
+			updated.Add(&newMetric)
+		}
+	}
+	for key := range metricsAggrs {
+		if !existing[key] {
+			delete(metricsAggrs, key)
+		}
+	}
+	return updated
+}
+
 func reduceCondStats(cond *data.Condition, ts time.Time, statsAggr stats.WindowAggregators, statsWindows []time.Duration) *data.Condition {
 	if cond.LastProbeTime == nil || cond.LastProbeTime.Time != ts {
 		return cond
@@ -1,6 +1,7 @@
 package reducers
 
 import (
+	"encoding/gob"
 	"time"
 
 	"github.com/golang/glog"
@@ -19,6 +20,10 @@ type ActiveConditionExtraData struct {
 	Region string `json:"region"`
 }
 
+func init() {
+	gob.Register(ActiveConditionExtraData{})
+}
+
 type StreamStateReducer struct {
 	exchange string
 }
@@ -47,10 +52,11 @@ func (t StreamStateReducer) Reduce(current *data.HealthStatus, _ interface{}, ev
 	}
 
 	conditions := current.ConditionsCopy()
-	if isActive {
-		// Clear all previous state when the stream becomes active.
-		// TODO: We should actually make the stream state indexed by the session ID
-		// not to need this. Would need to add session ID in all event payloads.
+	if isActive && current.SessionID == "" {
+		// Clear all previous state when a stream with no session information
+		// becomes active. Otherwise the core already keeps a separate state for
+		// each session, and attributes the events without a session ID to the
+		// current one, so they must not clear its state.
 		conditions = clearConditions(conditions)
 		current = data.NewHealthStatus(current.ID, conditions)
 	}
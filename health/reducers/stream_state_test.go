@@ -0,0 +1,43 @@
+package reducers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamStateReducerSessions(t *testing.T) {
+	require := require.New(t)
+	reducer := StreamStateReducer{}
+	start := time.Now()
+	newEvt := func(sessionID string, ts time.Time) *data.StreamStateEvent {
+		evt := data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: true})
+		evt.SessionID, evt.Timestamp_ = sessionID, data.UnixMillisTime{Time: ts}
+		return evt
+	}
+	transcoding := func(status *data.HealthStatus) *data.HealthStatus {
+		conditions, isTranscoding := status.ConditionsCopy(), true
+		conditions[1] = data.NewCondition(ConditionTranscoding, start, &isTranscoding, conditions[1])
+		return data.NewMergedHealthStatus(status, data.HealthStatus{Conditions: conditions})
+	}
+
+	// streams with no session information start over when they become active
+	status := transcoding(newTestStatus(ConditionActive, ConditionTranscoding))
+	status, _ = reducer.Reduce(status, nil, newEvt("", start))
+	require.True(*status.Condition(ConditionActive).Status)
+	require.Nil(status.Condition(ConditionTranscoding).Status)
+
+	// while the core attributes the events with no session ID to the current
+	// session, which must keep its state
+	status = newTestStatus(ConditionActive, ConditionTranscoding)
+	status.SessionID = "session-1"
+	status, _ = reducer.Reduce(status, nil, newEvt("session-1", start))
+	status = transcoding(status)
+	status, _ = reducer.Reduce(status, nil, newEvt("", start.Add(time.Second)))
+	require.Equal("session-1", status.SessionID)
+	require.True(*status.Condition(ConditionActive).Status)
+	require.True(*status.Condition(ConditionTranscoding).Status)
+	require.True(start.Add(time.Second).Equal(status.Condition(ConditionActive).LastProbeTime.Time))
+}
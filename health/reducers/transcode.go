@@ -22,6 +22,9 @@ var transcodeConditions = []data.ConditionType{ConditionTranscoding, ConditionTr
 type TranscodeReducer struct {
 	GolpExchange  string
 	ShardPrefixes []string
+	// MinRealtimeRatio is the minimum ratio of segment duration to transcode
+	// latency for the TranscodeRealTime condition. Defaults to 1 if zero.
+	MinRealtimeRatio float64
 }
 
 func (t TranscodeReducer) Bindings() []event.BindingArgs {
@@ -54,7 +57,7 @@ func (t TranscodeReducer) Reduce(current *data.HealthStatus, _ interface{}, evtI
 	ts := evt.Timestamp()
 	conditions := current.ConditionsCopy()
 	for i, cond := range conditions {
-		if status := conditionStatus(evt, cond.Type); status != nil {
+		if status := t.conditionStatus(evt, cond.Type); status != nil {
 			conditions[i] = data.NewCondition(cond.Type, ts, status, cond)
 		}
 	}
@@ -70,13 +73,13 @@ func (t TranscodeReducer) Reduce(current *data.HealthStatus, _ interface{}, evtI
 	}), nil
 }
 
-func conditionStatus(evt *data.TranscodeEvent, condType data.ConditionType) *bool {
+func (t TranscodeReducer) conditionStatus(evt *data.TranscodeEvent, condType data.ConditionType) *bool {
 	switch condType {
 	case ConditionTranscoding:
 		return &evt.Success
 	case ConditionTranscodeRealTime:
 		ratio, ok := realtimeRatio(evt)
-		isRealTime := ok && ratio >= 1
+		isRealTime := ok && ratio >= t.minRealtimeRatio()
 		return &isRealTime
 	case ConditionTranscodeNoErrors:
 		noErrors := true
@@ -89,6 +92,13 @@ func conditionStatus(evt *data.TranscodeEvent, condType data.ConditionType) *boo
 	}
 }
 
+func (t TranscodeReducer) minRealtimeRatio() float64 {
+	if t.MinRealtimeRatio <= 0 {
+		return 1
+	}
+	return t.MinRealtimeRatio
+}
+
 func realtimeRatio(evt *data.TranscodeEvent) (float64, bool) {
 	if evt.LatencyMs == 0 {
 		return 0, false
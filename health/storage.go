@@ -0,0 +1,121 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RecordStorage is the interface for the in-memory storage of the stream
+// health records. Implementations must be safe for concurrent use.
+type RecordStorage interface {
+	Get(id string) (*Record, bool)
+	GetOrCreate(id string, conditions []data.ConditionType) *Record
+	// Store saves the given record, replacing any existing one with the same ID.
+	Store(record *Record)
+	// Delete removes the record with the given ID from the storage and disposes
+	// of it, if it exists.
+	Delete(id string)
+	Range(f func(record *Record) bool)
+}
+
+// UpdateTrackingStorage is an optional interface for storages that need to know
+// when the core is done updating a record returned by GetOrCreate, e.g. to
+// account for its new size. UpdateDone is called once for every GetOrCreate,
+// after the record is unlocked.
+type UpdateTrackingStorage interface {
+	RecordStorage
+	UpdateDone(record *Record)
+}
+
+// StartCleanupLoop starts a background loop to remove records from the storage
+// which haven't received any event for longer than the given TTL.
+func StartCleanupLoop(ctx context.Context, storage RecordStorage, ttl time.Duration) {
+	go func() {
+		ticker := time.NewTicker(ttl / 100)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				threshold := time.Now().Add(-ttl)
+				recordsSize := 0
+				storage.Range(func(record *Record) bool {
+					recordsSize++
+					lastProbeTime := record.LastStatus.Healthy.LastProbeTime
+					if lastProbeTime != nil && lastProbeTime.Before(threshold) {
+						glog.Infof("Disposing of health record. id=%q, lastProbeTime=%q, ttl=%q", record.ID, lastProbeTime, ttl)
+						storage.Delete(record.ID)
+						recordStorageEvictions.WithLabelValues("ttl").Inc()
+						recordsSize--
+					}
+					return true
+				})
+				glog.Infof("Finished records clean-up loop. size=%d", recordsSize)
+			case <-ctx.Done():
+				// records are kept so they can still be persisted on shutdown
+				return
+			}
+		}
+	}()
+}
+
+// MapRecordStorage is a RecordStorage with no bounds on the amount of records
+// it keeps, which relies only on the clean-up loop to free up memory.
+type MapRecordStorage struct {
+	records   sync.Map
+	SizeGauge prometheus.Gauge
+}
+
+func NewMapRecordStorage() *MapRecordStorage {
+	return &MapRecordStorage{SizeGauge: recordStorageSize}
+}
+
+func (s *MapRecordStorage) Get(id string) (*Record, bool) {
+	if saved, ok := s.records.Load(id); ok {
+		return saved.(*Record), true
+	}
+	return nil, false
+}
+
+func (s *MapRecordStorage) Range(f func(record *Record) bool) {
+	s.records.Range(func(_ interface{}, value interface{}) bool {
+		return f(value.(*Record))
+	})
+}
+
+func (s *MapRecordStorage) Store(record *Record) {
+	prev, loaded := s.records.Swap(record.ID, record)
+	if loaded {
+		prev.(*Record).Dispose()
+	} else if s.SizeGauge != nil {
+		s.SizeGauge.Inc()
+	}
+}
+
+func (s *MapRecordStorage) Delete(id string) {
+	if saved, loaded := s.records.LoadAndDelete(id); loaded {
+		saved.(*Record).Dispose()
+		if s.SizeGauge != nil {
+			s.SizeGauge.Dec()
+		}
+	}
+}
+
+func (s *MapRecordStorage) GetOrCreate(id string, conditions []data.ConditionType) *Record {
+	if saved, ok := s.Get(id); ok {
+		return saved
+	}
+	new := NewRecord(id, conditions)
+	if actual, loaded := s.records.LoadOrStore(id, new); loaded {
+		return actual.(*Record)
+	}
+	glog.Infof("Created new health record. id=%q", id)
+	if s.SizeGauge != nil {
+		s.SizeGauge.Inc()
+	}
+	return new
+}
@@ -0,0 +1,267 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/livepeer/livepeer-data/metrics"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OverflowPolicy determines what happens when an event subscription can't
+// keep up with the events and its buffer gets full.
+type OverflowPolicy string
+
+const (
+	// OverflowGap drops the events while the buffer is full, then sends a
+	// data.SubscriptionGapEvent as soon as there's room again, with the ID of
+	// the last event before the gap so the subscriber can fetch the missed
+	// events by resuming from it.
+	OverflowGap OverflowPolicy = "gap"
+	// OverflowDisconnect ends the subscription, closing its channel. The
+	// subscriber can resume from the last event it received.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+var (
+	subscriptionOverflows = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: metrics.FQName("event_subscription_overflows_total"),
+		Help: "Count of times an event subscription buffer got full, partitioned by the overflow policy",
+	},
+		[]string{"policy"},
+	)
+	subscriptionDroppedEvents = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: metrics.FQName("event_subscription_dropped_events_total"),
+		Help: "Count of events not delivered to a subscription due to a full buffer, partitioned by the overflow policy",
+	},
+		[]string{"policy"},
+	)
+	subscriptionGaps = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: metrics.FQName("event_subscription_gaps_total"),
+		Help: "Count of gap events sent to subscriptions after dropping events, partitioned by the overflow policy",
+	},
+		[]string{"policy"},
+	)
+	subscriptionDisconnects = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: metrics.FQName("event_subscription_disconnects_total"),
+		Help: "Count of subscriptions ended due to a full buffer, partitioned by the overflow policy",
+	},
+		[]string{"policy"},
+	)
+)
+
+func ParseOverflowPolicy(str string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(str); policy {
+	case OverflowGap, OverflowDisconnect:
+		return policy, nil
+	case "":
+		return OverflowGap, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy: %q", str)
+	}
+}
+
+// EventFilter selects the events received by a subscription. The zero value
+// matches all events. NodeID and Region only match events that have them, as
+// returned by data.EventNode.
+type EventFilter struct {
+	EventTypes     []data.EventType
+	StreamIDPrefix string
+	NodeID, Region string
+}
+
+func (f EventFilter) Matches(evt data.Event) bool {
+	if !strings.HasPrefix(evt.StreamID(), f.StreamIDPrefix) {
+		return false
+	}
+	if f.NodeID != "" || f.Region != "" {
+		nodeID, region := data.EventNode(evt)
+		if f.NodeID != "" && f.NodeID != nodeID {
+			return false
+		} else if f.Region != "" && f.Region != region {
+			return false
+		}
+	}
+	if len(f.EventTypes) == 0 {
+		return true
+	}
+	for _, evtType := range f.EventTypes {
+		if evt.Type() == evtType {
+			return true
+		}
+	}
+	return false
+}
+
+func filterEvents(events []data.Event, filter EventFilter) []data.Event {
+	filtered := make([]data.Event, 0, len(events))
+	for _, evt := range events {
+		if filter.Matches(evt) {
+			filtered = append(filtered, evt)
+		}
+	}
+	return filtered
+}
+
+// EventSubscription is a subscription to the events of a stream, or of all of
+// them for the firehose. The events are queued by the owner record or firehose
+// and delivered to the channel by a goroutine of the subscription, so it knows
+// as soon as there's room in the queue for a gap event.
+type EventSubscription struct {
+	streamID string
+	filter   EventFilter
+	policy   OverflowPolicy
+	bufSize  int
+	events   chan data.Event
+	wake     chan struct{}
+
+	mu     sync.Mutex
+	queue  []data.Event
+	closed bool
+	// lastQueued is the ID of the last event queued for delivery, if any.
+	lastQueued *uuid.UUID
+	// dropped is the count of events dropped since the last one queued, and
+	// lastDropped the ID of the last of them.
+	dropped     int
+	lastDropped uuid.UUID
+}
+
+// newEventSubscription creates the subscription and starts delivering its
+// events until it is closed or the context is done.
+func newEventSubscription(ctx context.Context, streamID string, filter EventFilter, policy OverflowPolicy, bufSize int) *EventSubscription {
+	if policy == "" {
+		policy = OverflowGap
+	}
+	s := &EventSubscription{
+		streamID: streamID,
+		filter:   filter,
+		policy:   policy,
+		bufSize:  bufSize,
+		events:   make(chan data.Event),
+		wake:     make(chan struct{}, 1),
+	}
+	go s.deliver(ctx)
+	return s
+}
+
+func (s *EventSubscription) Events() <-chan data.Event {
+	return s.events
+}
+
+// send queues the event without blocking if it matches the filter, applying
+// the overflow policy if the queue is full. Returns false if the subscription
+// is closed and should be removed by the owner.
+func (s *EventSubscription) send(evt data.Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	} else if !s.filter.Matches(evt) {
+		return true
+	}
+	if s.dropped == 0 && len(s.queue) < s.bufSize {
+		s.enqueueLocked(evt)
+		return true
+	}
+
+	if s.dropped == 0 {
+		subscriptionOverflows.WithLabelValues(string(s.policy)).Inc()
+	}
+	switch s.policy {
+	case OverflowDisconnect:
+		glog.Warningf("Buffer full for event subscription, disconnecting subscriber. streamId=%q, lastEventId=%q", s.streamID, uuidPtrStr(s.lastQueued))
+		subscriptionDisconnects.WithLabelValues(string(s.policy)).Inc()
+		subscriptionDroppedEvents.WithLabelValues(string(s.policy)).Inc()
+		s.closeLocked()
+		return false
+	default:
+		if s.dropped == 0 {
+			glog.Warningf("Buffer full for event subscription, dropping events until there's room for a gap event. streamId=%q, lastEventId=%q", s.streamID, uuidPtrStr(s.lastQueued))
+		}
+		s.dropped++
+		s.lastDropped = evt.ID()
+		subscriptionDroppedEvents.WithLabelValues(string(s.policy)).Inc()
+		return true
+	}
+}
+
+func (s *EventSubscription) enqueueLocked(evt data.Event) {
+	s.queue = append(s.queue, evt)
+	if evt.Type() != data.EventTypeSubscriptionGap {
+		id := evt.ID()
+		s.lastQueued = &id
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends the queued events to the channel, queueing a gap event right
+// after the first one delivered once events have been dropped. The queued
+// events are still delivered after the subscription is closed, unless the
+// context is done since then there's no one receiving them anymore.
+func (s *EventSubscription) deliver(ctx context.Context) {
+	defer close(s.events)
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return
+			}
+			select {
+			case <-s.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		evt := s.queue[0]
+		s.mu.Unlock()
+
+		select {
+		case s.events <- evt:
+		case <-ctx.Done():
+			return
+		}
+
+		s.mu.Lock()
+		s.queue = s.queue[1:]
+		if s.dropped > 0 && !s.closed {
+			s.enqueueLocked(data.NewSubscriptionGapEvent(s.streamID, s.lastQueued, s.lastDropped, s.dropped))
+			s.dropped = 0
+			subscriptionGaps.WithLabelValues(string(s.policy)).Inc()
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *EventSubscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+}
+
+func (s *EventSubscription) closeLocked() {
+	if !s.closed {
+		s.closed = true
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func uuidPtrStr(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
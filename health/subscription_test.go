@@ -0,0 +1,82 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEvent(streamID string) data.Event {
+	return data.NewStreamStateEvent("node", "region", "user", streamID, data.StreamState{Active: true})
+}
+
+func TestEventSubscriptionGapPolicy(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := newEventSubscription(ctx, "stream", EventFilter{}, OverflowGap, 2)
+
+	events := []data.Event{newTestEvent("stream"), newTestEvent("stream"), newTestEvent("stream"), newTestEvent("stream")}
+	for _, evt := range events {
+		require.True(sub.send(evt))
+	}
+	require.Equal(events[0], <-sub.Events())
+
+	// the gap is queued as soon as there's room, even with no later events
+	require.Equal(events[1], <-sub.Events())
+	gap, ok := (<-sub.Events()).(*data.SubscriptionGapEvent)
+	require.True(ok)
+	require.Equal(events[1].ID(), *gap.LastEventID)
+	require.Equal(events[3].ID(), gap.LastDroppedEventID)
+	require.Equal(2, gap.DroppedEvents)
+
+	next := newTestEvent("stream")
+	require.True(sub.send(next))
+	require.Equal(next, <-sub.Events())
+
+	cancel()
+	_, ok = <-sub.Events()
+	require.False(ok)
+}
+
+func TestEventSubscriptionDisconnectPolicy(t *testing.T) {
+	require := require.New(t)
+	record := NewRecord("stream", nil)
+	sub := newEventSubscription(context.Background(), "stream", EventFilter{}, OverflowDisconnect, 1)
+	record.EventSubs = append(record.EventSubs, sub)
+
+	first := newTestEvent("stream")
+	record.PublishLocked(first)
+	record.PublishLocked(newTestEvent("stream"))
+	require.Empty(record.EventSubs)
+	require.False(sub.send(newTestEvent("stream")))
+
+	require.Equal(first, <-sub.Events())
+	_, ok := <-sub.Events()
+	require.False(ok)
+}
+
+func TestEventFilterMatches(t *testing.T) {
+	require := require.New(t)
+	stateEvt := data.NewStreamStateEvent("node-1", "region-1", "user", "video+1", data.StreamState{Active: true})
+	transcodeEvt := data.NewTranscodeEvent("node-2", "video+1", data.SegmentMetadata{}, time.Now(), true, nil)
+
+	require.True(EventFilter{}.Matches(stateEvt))
+	require.True(EventFilter{EventTypes: []data.EventType{"transcode", "stream_state"}}.Matches(stateEvt))
+	require.False(EventFilter{EventTypes: []data.EventType{"transcode"}}.Matches(stateEvt))
+	require.True(EventFilter{NodeID: "node-1", Region: "region-1"}.Matches(stateEvt))
+	require.False(EventFilter{NodeID: "node-1"}.Matches(transcodeEvt))
+	// transcode events have no region
+	require.False(EventFilter{Region: "region-1"}.Matches(transcodeEvt))
+	require.False(EventFilter{StreamIDPrefix: "other+"}.Matches(stateEvt))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := newEventSubscription(ctx, "video+1", EventFilter{EventTypes: []data.EventType{"transcode"}}, OverflowGap, 1)
+	require.True(sub.send(stateEvt))
+	require.True(sub.send(transcodeEvt))
+	require.Equal(transcodeEvt, <-sub.Events())
+}
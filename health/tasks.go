@@ -0,0 +1,251 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/livepeer-data/metrics"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	taskBindingKey           = "task.#"
+	taskSubscriptionBufSize  = 10
+	defaultTaskRecordsTtl    = 24 * time.Hour
+	taskCleanupLoopFrequency = 100
+)
+
+var (
+	ErrTaskNotFound         = errors.New("task not found")
+	ErrTaskTrackingDisabled = errors.New("task tracking is disabled")
+
+	tasksTracked = metrics.Factory.NewGauge(prometheus.GaugeOpts{
+		Name: metrics.FQName("tasks_tracked"),
+		Help: "Gauge for the current count of tasks tracked in memory",
+	})
+	taskStepDuration = metrics.Factory.NewSummaryVec(prometheus.SummaryOpts{
+		Name: metrics.FQName("task_step_duration_seconds"),
+		Help: "Duration of the steps of the tasks in seconds, partitioned by task type and step",
+	},
+		[]string{"task_type", "step"},
+	)
+	tasksFinished = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: metrics.FQName("tasks_finished_total"),
+		Help: "Count of tasks finished, partitioned by task type and final phase",
+	},
+		[]string{"task_type", "phase"},
+	)
+)
+
+type TaskTrackerOptions struct {
+	// Exchange is the AMQP exchange where the task events are published.
+	Exchange string
+	// RecordsTtl is how long to keep the tasks in memory after their last
+	// event. Defaults to 24h if not positive.
+	RecordsTtl time.Duration
+}
+
+// TaskTracker follows the lifecycle of the tasks from their trigger, partial
+// result and result events, keyed by the task ID. Task events don't have a
+// stream ID so they are not handled by the stream health records.
+type TaskTracker struct {
+	opts TaskTrackerOptions
+
+	mu    sync.RWMutex
+	tasks map[string]*taskRecord
+}
+
+type taskRecord struct {
+	status *data.TaskStatus
+	subs   []chan *data.TaskStatus
+}
+
+func NewTaskTracker(opts TaskTrackerOptions) *TaskTracker {
+	if opts.RecordsTtl <= 0 {
+		opts.RecordsTtl = defaultTaskRecordsTtl
+	}
+	return &TaskTracker{opts: opts, tasks: map[string]*taskRecord{}}
+}
+
+func (t *TaskTracker) Bindings() []event.BindingArgs {
+	return []event.BindingArgs{{Exchange: t.opts.Exchange, Key: taskBindingKey}}
+}
+
+// HandleEvent updates the status of the task of the event. Returns false if
+// the event is not a task event.
+func (t *TaskTracker) HandleEvent(evt data.Event) bool {
+	info, ok := data.TaskEventInfo(evt)
+	if !ok {
+		return false
+	} else if info.ID == "" {
+		glog.Warningf("Task tracker ignoring event with no task ID. eventID=%s, type=%s", evt.ID(), evt.Type())
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	record, ok := t.tasks[info.ID]
+	if !ok {
+		record = &taskRecord{}
+		t.tasks[info.ID] = record
+		tasksTracked.Set(float64(len(t.tasks)))
+	}
+	status := reduceTaskStatus(record.status, info, evt)
+	if status == record.status {
+		return true
+	}
+	record.status = status
+
+	for _, subs := range record.subs {
+		sendLatest(subs, status)
+	}
+	return true
+}
+
+// reduceTaskStatus returns the new status of a task after the given event, or
+// the current one if the event doesn't change it.
+func reduceTaskStatus(current *data.TaskStatus, info data.TaskInfo, evt data.Event) *data.TaskStatus {
+	ts := data.UnixMillisTime{Time: evt.Timestamp()}
+	var status data.TaskStatus
+	if current != nil {
+		if ts.Before(current.UpdatedAt.Time) {
+			glog.Warningf("Task tracker ignoring out of order event. taskID=%s, eventID=%s, type=%s", info.ID, evt.ID(), evt.Type())
+			return current
+		}
+		status = *current
+		status.Steps = current.StepsCopy()
+	} else {
+		status = data.TaskStatus{ID: info.ID, StartTime: ts}
+	}
+	status.Type, status.UpdatedAt = info.Type, ts
+
+	switch evt := evt.(type) {
+	case *data.TaskTriggerEvent:
+		if status.Phase == data.TaskPhaseFailed {
+			status.Retries++
+		}
+		endLastStep(&status, ts)
+		status.Phase, status.Step = data.TaskPhaseRunning, info.Step
+		status.Steps = append(status.Steps, data.TaskStep{Name: info.Step, StartTime: ts})
+		status.EndTime, status.Output, status.Error = nil, nil, nil
+	case *data.TaskResultPartialEvent:
+		status.PartialOutput = evt.Output
+	case *data.TaskResultEvent:
+		endLastStep(&status, ts)
+		status.Phase, status.EndTime = data.TaskPhaseCompleted, &ts
+		status.Output, status.Error = evt.Output, evt.Error
+		if evt.Error != nil {
+			status.Phase = data.TaskPhaseFailed
+		}
+		tasksFinished.WithLabelValues(status.Type, string(status.Phase)).Inc()
+	}
+	return &status
+}
+
+func endLastStep(status *data.TaskStatus, ts data.UnixMillisTime) {
+	if len(status.Steps) == 0 {
+		return
+	}
+	step := &status.Steps[len(status.Steps)-1]
+	if step.EndTime != nil {
+		return
+	}
+	step.EndTime = &ts
+	duration := ts.Sub(step.StartTime.Time)
+	step.DurationMs = duration.Milliseconds()
+	taskStepDuration.WithLabelValues(status.Type, step.Name).Observe(duration.Seconds())
+}
+
+// sendLatest sends the value without blocking, discarding the oldest one in the
+// buffer if it is full since only the latest status matters.
+func sendLatest[T any](subs chan T, value T) {
+	for {
+		select {
+		case subs <- value:
+			return
+		default:
+		}
+		select {
+		case <-subs:
+		default:
+		}
+	}
+}
+
+func (t *TaskTracker) GetStatus(taskID string) (*data.TaskStatus, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	record, ok := t.tasks[taskID]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	return record.status, nil
+}
+
+// Subscribe returns the current status of the task and a channel that
+// receives every new status. The channel is closed when the context is done
+// or once the task is removed from memory.
+func (t *TaskTracker) Subscribe(ctx context.Context, taskID string) (*data.TaskStatus, <-chan *data.TaskStatus, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	record, ok := t.tasks[taskID]
+	if !ok {
+		return nil, nil, ErrTaskNotFound
+	}
+	subs := make(chan *data.TaskStatus, taskSubscriptionBufSize)
+	record.subs = append(record.subs, subs)
+	go func() {
+		<-ctx.Done()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.unsubscribeLocked(record, subs)
+	}()
+	return record.status, subs, nil
+}
+
+func (t *TaskTracker) unsubscribeLocked(record *taskRecord, subs chan *data.TaskStatus) {
+	for i := range record.subs {
+		if record.subs[i] == subs {
+			record.subs = append(record.subs[:i], record.subs[i+1:]...)
+			close(subs)
+			return
+		}
+	}
+}
+
+// StartCleanupLoop removes the tasks with no events for longer than the
+// records TTL until the context is done.
+func (t *TaskTracker) StartCleanupLoop(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(t.opts.RecordsTtl / taskCleanupLoopFrequency)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.cleanup(time.Now().Add(-t.opts.RecordsTtl))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (t *TaskTracker) cleanup(threshold time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, record := range t.tasks {
+		if record.status.UpdatedAt.After(threshold) {
+			continue
+		}
+		for len(record.subs) > 0 {
+			t.unsubscribeLocked(record, record.subs[0])
+		}
+		delete(t.tasks, id)
+	}
+	tasksTracked.Set(float64(len(t.tasks)))
+}
@@ -0,0 +1,78 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskTrackerLifecycle(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker := NewTaskTracker(TaskTrackerOptions{Exchange: "lp_tasks"})
+
+	start := time.Now().Truncate(time.Millisecond)
+	at := func(evt data.Event, offset time.Duration) data.Event {
+		switch evt := evt.(type) {
+		case *data.TaskTriggerEvent:
+			evt.Timestamp_ = data.UnixMillisTime{Time: start.Add(offset)}
+		case *data.TaskResultPartialEvent:
+			evt.Timestamp_ = data.UnixMillisTime{Time: start.Add(offset)}
+		case *data.TaskResultEvent:
+			evt.Timestamp_ = data.UnixMillisTime{Time: start.Add(offset)}
+		}
+		return evt
+	}
+	info := data.TaskInfo{ID: "task-1", Type: "upload", Step: ""}
+
+	require.False(tracker.HandleEvent(data.NewStreamStateEvent("node", "region", "user", "stream", data.StreamState{})))
+	_, err := tracker.GetStatus("task-1")
+	require.ErrorIs(err, ErrTaskNotFound)
+
+	require.True(tracker.HandleEvent(at(data.NewTaskTriggerEvent(info), 0)))
+	status, subs, err := tracker.Subscribe(ctx, "task-1")
+	require.NoError(err)
+	require.Equal(data.TaskPhaseRunning, status.Phase)
+
+	partial := &data.TaskPartialOutput{Upload: &data.UploadTaskOutput{VideoFilePath: "video.mp4"}}
+	tracker.HandleEvent(at(data.NewTaskResultPartialEvent(info, partial), time.Second))
+	info.Step = "finalize"
+	tracker.HandleEvent(at(data.NewTaskTriggerEvent(info), 2*time.Second))
+	tracker.HandleEvent(at(data.NewTaskResultEvent(info, &data.ErrorInfo{Message: "boom"}, nil), 5*time.Second))
+	// retried after failing
+	tracker.HandleEvent(at(data.NewTaskTriggerEvent(info), 6*time.Second))
+	tracker.HandleEvent(at(data.NewTaskResultEvent(info, nil, &data.TaskOutput{}), 8*time.Second))
+
+	status, err = tracker.GetStatus("task-1")
+	require.NoError(err)
+	require.Equal(data.TaskPhaseCompleted, status.Phase)
+	require.True(status.Finished())
+	require.Equal(1, status.Retries)
+	require.Nil(status.Error)
+	require.NotNil(status.Output)
+	require.Equal(partial, status.PartialOutput)
+	require.Equal(start, status.StartTime.Time)
+	require.Equal(start.Add(8*time.Second), status.EndTime.Time)
+	require.Len(status.Steps, 3)
+	require.Equal("", status.Steps[0].Name)
+	require.EqualValues(2000, status.Steps[0].DurationMs)
+	require.Equal("finalize", status.Steps[1].Name)
+	require.EqualValues(3000, status.Steps[1].DurationMs)
+	require.EqualValues(2000, status.Steps[2].DurationMs)
+
+	var last *data.TaskStatus
+	for len(subs) > 0 {
+		last = <-subs
+	}
+	require.Equal(status, last)
+
+	tracker.cleanup(start.Add(time.Minute))
+	_, err = tracker.GetStatus("task-1")
+	require.ErrorIs(err, ErrTaskNotFound)
+	_, ok := <-subs
+	require.False(ok)
+}
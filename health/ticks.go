@@ -0,0 +1,186 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/uuid"
+	"github.com/livepeer/livepeer-data/metrics"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const eventTypeReducerTick data.EventType = "reducer_tick"
+
+var (
+	reducerTicksCount = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Name: metrics.FQName("reducer_ticks_total"),
+		Help: "Count of reducer ticks dispatched to live streams",
+	})
+	reducerTickChanges = metrics.Factory.NewCounter(prometheus.CounterOpts{
+		Name: metrics.FQName("reducer_tick_changes_total"),
+		Help: "Count of reducer ticks that changed the status of a stream",
+	})
+)
+
+// reducerTick is dispatched to the event workers like an event, so it is
+// processed in order with the events of the stream. It is never stored or
+// published like the actual events.
+type reducerTick struct {
+	streamID string
+	now      time.Time
+}
+
+var _ data.Event = (*reducerTick)(nil)
+
+func (t *reducerTick) Type() data.EventType { return eventTypeReducerTick }
+func (t *reducerTick) ID() uuid.UUID        { return uuid.Nil }
+func (t *reducerTick) Timestamp() time.Time { return t.now }
+func (t *reducerTick) StreamID() string     { return t.streamID }
+
+func (c *Core) tickLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			c.dispatchTicks(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// dispatchTicks sends a tick to the worker of every live stream. Like events,
+// ticks are dispatched with the persistence lock held and logged to the
+// write-ahead log, so the expirations are restored after a restart.
+func (c *Core) dispatchTicks(now time.Time) {
+	if c.persistence != nil {
+		c.persistence.Lock()
+		defer c.persistence.Unlock()
+	}
+	streamIDs := c.liveStreamIDs()
+	ticks := make([]workerEvent, len(streamIDs))
+	for i, streamID := range streamIDs {
+		ticks[i] = workerEvent{evt: &reducerTick{streamID, now}}
+	}
+	if len(ticks) == 0 || !c.workers.Dispatch(ticks) {
+		return
+	}
+	reducerTicksCount.Add(float64(len(ticks)))
+
+	if c.persistence != nil {
+		if err := c.persistence.AppendTickLocked(c.lastOffset, now); err != nil {
+			glog.Errorf("Health core failed to persist reducer tick. err=%q, now=%s", err, now)
+		}
+		if err := c.persistence.FlushLocked(); err != nil {
+			glog.Errorf("Error flushing health records write-ahead log. err=%q", err)
+		}
+	}
+}
+
+// replayTick applies a tick from the write-ahead log to every live stream
+// synchronously, since the restore happens before the workers are used.
+func (c *Core) replayTick(now time.Time) {
+	reducer, ok := c.reducer.(TickReducer)
+	if !ok {
+		return
+	}
+	for _, streamID := range c.liveStreamIDs() {
+		c.handleTick(reducer, &reducerTick{streamID, now}, true)
+	}
+}
+
+// liveStreamIDs returns the streams that have any condition with a true
+// status. Streams with everything false or unknown have nothing that could
+// expire.
+func (c *Core) liveStreamIDs() []string {
+	var streamIDs []string
+	c.storage.Range(func(record *Record) bool {
+		record.RLock()
+		live := isLiveStatus(record.LastStatus)
+		record.RUnlock()
+		if live {
+			streamIDs = append(streamIDs, record.ID)
+		}
+		return true
+	})
+	return streamIDs
+}
+
+func isLiveStatus(status *data.HealthStatus) bool {
+	for _, cond := range status.Conditions {
+		if cond.Status != nil && *cond.Status {
+			return true
+		}
+	}
+	return false
+}
+
+// handleTick runs the tick reducer on the current status of the stream, which
+// is the one of its current session if it has any. Transitions are not notified
+// when replaying the write-ahead log, like for events.
+func (c *Core) handleTick(reducer TickReducer, tick *reducerTick, replay bool) {
+	record, ok := c.storage.Get(tick.streamID)
+	if !ok {
+		return
+	}
+	record.RLock()
+	status, state := record.LastStatus, record.ReducerState
+	session := record.CurrentSessionLocked()
+	if session != nil {
+		status, state = session.LastStatus, session.ReducerState
+	}
+	record.RUnlock()
+
+	newStatus, newState, err := reduceTick(reducer, status, state, tick.now)
+	if err != nil {
+		glog.Errorf("Health core failed to process reducer tick. streamID=%s, err=%q", tick.streamID, err)
+		return
+	} else if newStatus == status {
+		return
+	}
+	reducerTickChanges.Inc()
+	glog.V(4).Infof("Health core reducer tick changed stream status. streamID=%s, now=%s", tick.streamID, tick.now)
+	if notifier := c.opts.TransitionNotifier; notifier != nil && !replay {
+		notifier.StatusChanged(status, newStatus)
+	}
+
+	record.Lock()
+	defer record.Unlock()
+	if session == nil {
+		record.LastStatus, record.ReducerState = newStatus, newState
+	} else {
+		session.LastStatus, session.ReducerState = newStatus, newState
+		if session == record.CurrentSessionLocked() {
+			record.LastStatus = newStatus
+		}
+	}
+	if !replay && record.LastStatus == newStatus {
+		record.PublishStatusLocked()
+	}
+	forEachTransition(status, newStatus, func(condType data.ConditionType, previous *bool, cond *data.Condition) {
+		record.AddTransitionLocked(condType, ConditionTransition{
+			Time:           cond.LastTransitionTime.Time,
+			SessionID:      newStatus.SessionID,
+			PreviousStatus: previous,
+			Status:         cond.Status,
+		}, maxConditionHistory)
+	})
+}
+
+// reduceTick recovers from panics in the reducer like reduceRecv.
+func reduceTick(reducer TickReducer, currStatus *data.HealthStatus, currState interface{}, now time.Time) (newStatus *data.HealthStatus, newState interface{}, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			glog.Errorf("Panic in health tick reducer. panicValue=%q now=%s stack=%q", rec, now, debug.Stack())
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+
+	newStatus, newState = reducer.ReduceTick(currStatus, currState, now)
+	return
+}
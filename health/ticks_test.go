@@ -0,0 +1,195 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
+	"github.com/stretchr/testify/require"
+)
+
+const tickTestCondition data.ConditionType = "Active"
+
+// expiringReducer sets the condition from stream state events and expires it
+// to false on ticks a minute after the last probe.
+type expiringReducer struct{}
+
+func (r expiringReducer) Bindings() []event.BindingArgs { return nil }
+
+func (r expiringReducer) Conditions() []data.ConditionType {
+	return []data.ConditionType{tickTestCondition}
+}
+
+func (r expiringReducer) Reduce(current *data.HealthStatus, state interface{}, evt data.Event) (*data.HealthStatus, interface{}) {
+	active := evt.(*data.StreamStateEvent).State.Active
+	return r.setStatus(current, evt.Timestamp(), active), state
+}
+
+func (r expiringReducer) ReduceTick(current *data.HealthStatus, state interface{}, now time.Time) (*data.HealthStatus, interface{}) {
+	cond := current.Condition(tickTestCondition)
+	if cond.Status == nil || !*cond.Status || now.Sub(cond.LastProbeTime.Time) < time.Minute {
+		return current, state
+	}
+	return r.setStatus(current, now, false), state
+}
+
+func (r expiringReducer) setStatus(current *data.HealthStatus, ts time.Time, status bool) *data.HealthStatus {
+	conditions := current.ConditionsCopy()
+	conditions[0] = data.NewCondition(tickTestCondition, ts, &status, current.Condition(tickTestCondition))
+	return data.NewMergedHealthStatus(current, data.HealthStatus{Conditions: conditions})
+}
+
+func TestCoreReducerTicksExpireConditions(t *testing.T) {
+	require := require.New(t)
+	core := &Core{
+		reducer:        expiringReducer{},
+		storage:        NewMapRecordStorage(),
+		conditionTypes: []data.ConditionType{tickTestCondition},
+		opts:           CoreOptions{StartTimeOffset: time.Hour},
+	}
+	core.workers = newEventWorkers(2, 10, core.processEvent)
+	defer core.workers.Close()
+
+	start := time.Now()
+	evt := data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: true})
+	evt.Timestamp_ = data.UnixMillisTime{Time: start}
+	require.NoError(core.handleSingleEvent(evt, false))
+	status, err := core.GetStatus("stream-1")
+	require.NoError(err)
+	require.True(*status.Condition(tickTestCondition).Status)
+
+	core.dispatchTicks(start.Add(30 * time.Second))
+	core.workers.WaitPending()
+	current, err := core.GetStatus("stream-1")
+	require.NoError(err)
+	require.Same(status, current)
+
+	core.dispatchTicks(start.Add(2 * time.Minute))
+	core.workers.WaitPending()
+	status, err = core.GetStatus("stream-1")
+	require.NoError(err)
+	require.False(*status.Condition(tickTestCondition).Status)
+
+	history, err := core.GetConditionHistory("stream-1", tickTestCondition, nil, nil)
+	require.NoError(err)
+	require.Len(history, 2)
+	require.True(*history[1].PreviousStatus)
+	require.False(*history[1].Status)
+	require.Equal(start.Add(2*time.Minute), history[1].Time)
+
+	// ticks are not events, so they are not stored or published
+	events, err := core.GetPastEvents("stream-1", EventFilter{}, nil, nil)
+	require.NoError(err)
+	require.Len(events, 1)
+
+	// streams with no true conditions are not live anymore
+	require.False(isLiveStatus(status))
+}
+
+func TestCoreSubscribeStatusReceivesTicks(t *testing.T) {
+	require := require.New(t)
+	core := &Core{
+		reducer:        expiringReducer{},
+		storage:        NewMapRecordStorage(),
+		conditionTypes: []data.ConditionType{tickTestCondition},
+		opts:           CoreOptions{StartTimeOffset: time.Hour},
+	}
+	core.workers = newEventWorkers(2, 10, core.processEvent)
+	defer core.workers.Close()
+
+	start := time.Now()
+	evt := data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: true})
+	evt.Timestamp_ = data.UnixMillisTime{Time: start}
+	require.NoError(core.handleSingleEvent(evt, false))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	status, subscription, err := core.SubscribeStatus(ctx, "stream-1")
+	require.NoError(err)
+	require.True(*status.Condition(tickTestCondition).Status)
+
+	// ticks that don't change the status are not sent
+	core.dispatchTicks(start.Add(30 * time.Second))
+	core.workers.WaitPending()
+	require.Empty(subscription)
+
+	core.dispatchTicks(start.Add(2 * time.Minute))
+	core.workers.WaitPending()
+	select {
+	case status = <-subscription:
+		require.False(*status.Condition(tickTestCondition).Status)
+	case <-time.After(time.Second):
+		require.Fail("expired status not received")
+	}
+	current, err := core.GetStatus("stream-1")
+	require.NoError(err)
+	require.Same(current, status)
+
+	cancel()
+	select {
+	case _, ok := <-subscription:
+		require.False(ok)
+	case <-time.After(time.Second):
+		require.Fail("subscription not closed")
+	}
+}
+
+func TestCoreReducerTicksArePersisted(t *testing.T) {
+	require := require.New(t)
+	dir := t.TempDir()
+	newCore := func() *Core {
+		core := newPersistedCore(t, dir)
+		core.reducer, core.conditionTypes = expiringReducer{}, []data.ConditionType{tickTestCondition}
+		return core
+	}
+
+	core := newCore()
+	start := time.Now()
+	evt := data.NewStreamStateEvent("node", "region", "user", "stream-1", data.StreamState{Active: true})
+	evt.Timestamp_ = data.UnixMillisTime{Time: start}
+	handleEvents(t, core, evt)
+	core.workers.WaitPending()
+	core.dispatchTicks(start.Add(2 * time.Minute))
+	// snapshots wait for the ticks dispatched before them
+	require.NoError(core.snapshot())
+	core.dispatchTicks(start.Add(3 * time.Minute))
+	core.workers.Close()
+	require.NoError(core.persistence.Close())
+
+	producer := &recordingProducer{}
+	restored := newCore()
+	restored.opts.TransitionNotifier = NewTransitionNotifier(TransitionNotifierOptions{}, producer)
+	defer restored.opts.TransitionNotifier.Close()
+	require.NoError(restored.restore())
+	defer restored.persistence.Close()
+	defer restored.workers.Close()
+
+	status, err := restored.GetStatus("stream-1")
+	require.NoError(err)
+	require.False(*status.Condition(tickTestCondition).Status)
+	history, err := restored.GetConditionHistory("stream-1", tickTestCondition, nil, nil)
+	require.NoError(err)
+	require.Len(history, 2)
+	require.True(start.Add(2 * time.Minute).Equal(history[1].Time))
+
+	// expire the condition only from the write-ahead log
+	dir = t.TempDir()
+	core = newCore()
+	handleEvents(t, core, evt)
+	core.workers.WaitPending()
+	core.dispatchTicks(start.Add(2 * time.Minute))
+	core.workers.Close()
+	require.NoError(core.persistence.Close())
+
+	restored = newCore()
+	require.NoError(restored.restore())
+	defer restored.persistence.Close()
+	defer restored.workers.Close()
+	status, err = restored.GetStatus("stream-1")
+	require.NoError(err)
+	require.False(*status.Condition(tickTestCondition).Status)
+
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(producer.transitions())
+}
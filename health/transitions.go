@@ -0,0 +1,257 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/livepeer-data/metrics"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	transitionsNotified = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: metrics.FQName("health_transitions_notified_total"),
+		Help: "Count of health condition transitions published, partitioned by condition type",
+	},
+		[]string{"condition"},
+	)
+	transitionsSuppressed = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: metrics.FQName("health_transitions_suppressed_total"),
+		Help: "Count of health condition transitions not published due to debouncing or flapping, partitioned by condition type",
+	},
+		[]string{"condition"},
+	)
+)
+
+type TransitionNotifierOptions struct {
+	// Exchange is the AMQP exchange where to publish the transition events. The
+	// routing key is "<KeyNs>.<streamID>.<condition>".
+	Exchange, KeyNs string
+	// Debounce is how long a condition must keep a new status before the
+	// transition is published. Transitions that are reverted during this time
+	// are never published. Publishes immediately if zero.
+	Debounce time.Duration
+	// FlapThreshold is the number of transitions of a condition within the
+	// FlapWindow for it to be considered flapping. While flapping, the
+	// transitions are only published once the condition is stable for a full
+	// FlapWindow. Flap suppression is disabled if zero.
+	FlapThreshold int
+	FlapWindow    time.Duration
+}
+
+// TransitionNotifier publishes a data.HealthTransitionEvent for every change
+// in the status of the conditions of a stream, including its Healthy condition.
+type TransitionNotifier struct {
+	opts     TransitionNotifierOptions
+	producer event.AMQPProducer
+
+	mu     sync.Mutex
+	closed bool
+	conds  map[transitionKey]*conditionTransitions
+}
+
+type transitionKey struct {
+	streamID, sessionID string
+	condition           data.ConditionType
+}
+
+// conditionTransitions tracks the pending transitions of a single condition.
+type conditionTransitions struct {
+	// published is the last status that was published or, if none was yet, the
+	// status from before the first transition.
+	published      *bool
+	status         *bool
+	transitionTime time.Time
+	// pending is whether there are transitions not flushed yet.
+	pending bool
+	// recent are the times of the transitions within the flap window.
+	recent []time.Time
+	timer  *time.Timer
+}
+
+func NewTransitionNotifier(opts TransitionNotifierOptions, producer event.AMQPProducer) *TransitionNotifier {
+	return &TransitionNotifier{
+		opts:     opts,
+		producer: producer,
+		conds:    map[transitionKey]*conditionTransitions{},
+	}
+}
+
+// Close stops all pending notifications. The producer is not shutdown since
+// the notifier doesn't own it.
+func (n *TransitionNotifier) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	for key, cond := range n.conds {
+		if cond.timer != nil {
+			cond.timer.Stop()
+		}
+		delete(n.conds, key)
+	}
+}
+
+// StatusChanged diffs the old and new status of a stream, handling every
+// condition whose LastTransitionTime has changed.
+func (n *TransitionNotifier) StatusChanged(old, new *data.HealthStatus) {
+	forEachTransition(old, new, func(condType data.ConditionType, previous *bool, cond *data.Condition) {
+		n.conditionChanged(new.ID, new.SessionID, condType, previous, cond)
+	})
+}
+
+// forEachTransition calls fn for every condition of the new status whose
+// LastTransitionTime differs from the old status, including the Healthy one
+// which is identified by data.ConditionHealthy.
+func forEachTransition(old, new *data.HealthStatus, fn func(condType data.ConditionType, previous *bool, cond *data.Condition)) {
+	if new == nil || old == new {
+		return
+	}
+	check := func(condType data.ConditionType, oldCond, newCond *data.Condition) {
+		if newCond == nil || newCond.LastTransitionTime == nil {
+			return
+		}
+		var previous *bool
+		if oldCond != nil {
+			if oldCond.LastTransitionTime != nil && oldCond.LastTransitionTime.Equal(newCond.LastTransitionTime.Time) {
+				return
+			}
+			previous = oldCond.Status
+		}
+		fn(condType, previous, newCond)
+	}
+
+	var oldHealthy *data.Condition
+	if old != nil {
+		oldHealthy = old.Healthy
+	}
+	check(data.ConditionHealthy, oldHealthy, new.Healthy)
+	for _, cond := range new.Conditions {
+		var oldCond *data.Condition
+		if old != nil {
+			oldCond = old.Condition(cond.Type)
+		}
+		check(cond.Type, oldCond, cond)
+	}
+}
+
+func (n *TransitionNotifier) conditionChanged(streamID, sessionID string, condType data.ConditionType, previous *bool, new *data.Condition) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	key := transitionKey{streamID, sessionID, condType}
+	cond, ok := n.conds[key]
+	if !ok {
+		cond = &conditionTransitions{published: previous}
+		n.conds[key] = cond
+	} else if cond.timer != nil {
+		cond.timer.Stop()
+		transitionsSuppressed.WithLabelValues(string(condType)).Inc()
+	}
+	now := time.Now()
+	cond.status, cond.transitionTime, cond.pending = new.Status, new.LastTransitionTime.Time, true
+	cond.recent = append(pruneBefore(cond.recent, now.Add(-n.opts.FlapWindow)), now)
+
+	delay := n.opts.Debounce
+	if n.isFlapping(cond) {
+		if delay < n.opts.FlapWindow {
+			delay = n.opts.FlapWindow
+		}
+		glog.V(5).Infof("Health condition flapping, delaying transition notification. streamID=%s sessionID=%s condition=%s recentTransitions=%d",
+			streamID, sessionID, condType, len(cond.recent))
+	}
+	if delay <= 0 {
+		n.flushLocked(key, cond, now)
+		return
+	}
+	n.scheduleFlushLocked(key, cond, delay)
+}
+
+func (n *TransitionNotifier) scheduleFlushLocked(key transitionKey, cond *conditionTransitions, delay time.Duration) {
+	cond.timer = time.AfterFunc(delay, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if !n.closed && n.conds[key] == cond {
+			n.flushLocked(key, cond, time.Now())
+		}
+	})
+}
+
+func (n *TransitionNotifier) isFlapping(cond *conditionTransitions) bool {
+	return n.opts.FlapThreshold > 0 && len(cond.recent) >= n.opts.FlapThreshold
+}
+
+// flushLocked publishes the current status of the condition if it differs
+// from the last published one, then keeps tracking it only for as long as its
+// transitions are needed for flap detection.
+func (n *TransitionNotifier) flushLocked(key transitionKey, cond *conditionTransitions, now time.Time) {
+	cond.timer = nil
+	if !boolPtrEqual(cond.published, cond.status) {
+		n.publish(key, cond.published, cond.status, cond.transitionTime)
+		cond.published = cond.status
+	} else if cond.pending {
+		// the condition went back to the published status before the flush
+		transitionsSuppressed.WithLabelValues(string(key.condition)).Inc()
+	}
+	cond.pending = false
+
+	cond.recent = pruneBefore(cond.recent, now.Add(-n.opts.FlapWindow))
+	if n.opts.FlapThreshold <= 0 || len(cond.recent) == 0 {
+		delete(n.conds, key)
+		return
+	}
+	n.scheduleFlushLocked(key, cond, cond.recent[0].Add(n.opts.FlapWindow).Sub(now))
+}
+
+func (n *TransitionNotifier) publish(key transitionKey, previous, status *bool, transitionTime time.Time) {
+	evt := data.NewHealthTransitionEvent(key.streamID, key.sessionID, key.condition, previous, status, transitionTime)
+	routingKey := key.streamID + "." + string(key.condition)
+	if n.opts.KeyNs != "" {
+		routingKey = n.opts.KeyNs + "." + routingKey
+	}
+	glog.V(4).Infof("Publishing health transition. streamID=%s sessionID=%s condition=%s previous=%v status=%v",
+		key.streamID, key.sessionID, key.condition, boolPtrStr(previous), boolPtrStr(status))
+
+	// With WaitResult=false this only enqueues the message in the producer.
+	err := n.producer.Publish(context.Background(), event.AMQPMessage{
+		Exchange:   n.opts.Exchange,
+		Key:        routingKey,
+		Body:       evt,
+		Persistent: true,
+	})
+	if err != nil {
+		glog.Errorf("Error publishing health transition. streamID=%s condition=%s err=%q", key.streamID, key.condition, err)
+		return
+	}
+	transitionsNotified.WithLabelValues(string(key.condition)).Inc()
+}
+
+func pruneBefore(times []time.Time, threshold time.Time) []time.Time {
+	idx := 0
+	for idx < len(times) && times[idx].Before(threshold) {
+		idx++
+	}
+	return times[idx:]
+}
+
+func boolPtrEqual(a, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func boolPtrStr(b *bool) string {
+	if b == nil {
+		return "nil"
+	}
+	if *b {
+		return "true"
+	}
+	return "false"
+}
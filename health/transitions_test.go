@@ -0,0 +1,101 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/livepeer/livepeer-data/pkg/event"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingProducer struct {
+	mu   sync.Mutex
+	msgs []event.AMQPMessage
+}
+
+func (p *recordingProducer) Publish(_ context.Context, msg event.AMQPMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.msgs = append(p.msgs, msg)
+	return nil
+}
+
+func (p *recordingProducer) Shutdown(context.Context) error {
+	return nil
+}
+
+func (p *recordingProducer) transitions() []*data.HealthTransitionEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	evts := make([]*data.HealthTransitionEvent, len(p.msgs))
+	for i, msg := range p.msgs {
+		evts[i] = msg.Body.(*data.HealthTransitionEvent)
+	}
+	return evts
+}
+
+func TestTransitionNotifierDebounce(t *testing.T) {
+	require := require.New(t)
+	producer := &recordingProducer{}
+	notifier := NewTransitionNotifier(TransitionNotifierOptions{
+		Exchange: "exchange",
+		KeyNs:    "ns",
+		Debounce: 50 * time.Millisecond,
+	}, producer)
+	defer notifier.Close()
+
+	status := newHealthyStatus(nil, true)
+	// reverted transitions are never published
+	flipped := newHealthyStatus(status, false)
+	notifier.StatusChanged(status, flipped)
+	notifier.StatusChanged(flipped, newHealthyStatus(flipped, true))
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(producer.transitions())
+
+	notifier.StatusChanged(status, newHealthyStatus(status, false))
+	require.Eventually(func() bool { return len(producer.transitions()) == 1 }, time.Second, 10*time.Millisecond)
+	evt := producer.transitions()[0]
+	require.Equal("stream-1", evt.StreamID())
+	require.Equal(data.ConditionHealthy, evt.Condition)
+	require.True(*evt.PreviousStatus)
+	require.False(*evt.Status)
+	require.Equal("ns.stream-1.Healthy", producer.msgs[0].Key)
+}
+
+func TestTransitionNotifierFlapping(t *testing.T) {
+	require := require.New(t)
+	producer := &recordingProducer{}
+	notifier := NewTransitionNotifier(TransitionNotifierOptions{
+		FlapThreshold: 3,
+		FlapWindow:    200 * time.Millisecond,
+	}, producer)
+	defer notifier.Close()
+
+	status := newHealthyStatus(nil, true)
+	for i := 0; i < 5; i++ {
+		next := newHealthyStatus(status, i%2 == 1)
+		notifier.StatusChanged(status, next)
+		status = next
+	}
+	// the first transitions are published immediately since there's no debounce
+	require.Len(producer.transitions(), 2)
+
+	time.Sleep(100 * time.Millisecond)
+	require.Len(producer.transitions(), 2)
+	require.Eventually(func() bool { return len(producer.transitions()) == 3 }, time.Second, 10*time.Millisecond)
+	require.False(*producer.transitions()[2].Status)
+}
+
+func newHealthyStatus(last *data.HealthStatus, healthy bool) *data.HealthStatus {
+	// transition times must be distinct for the notifier to see the changes
+	time.Sleep(time.Millisecond)
+	var lastHealthy *data.Condition
+	if last != nil {
+		lastHealthy = last.Healthy
+	}
+	cond := data.NewCondition("", time.Now(), &healthy, lastHealthy)
+	return data.NewMergedHealthStatus(last, data.HealthStatus{ID: "stream-1", Healthy: cond})
+}
@@ -0,0 +1,135 @@
+package health
+
+import (
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/livepeer/livepeer-data/metrics"
+	"github.com/livepeer/livepeer-data/pkg/data"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	workerQueueLength = metrics.Factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: metrics.FQName("event_worker_queue_length"),
+		Help: "Gauge for the current count of events waiting in the queue of each event processing worker",
+	},
+		[]string{"worker"},
+	)
+	workerEventsProcessed = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: metrics.FQName("event_worker_events_processed_total"),
+		Help: "Count of events processed by each event processing worker",
+	},
+		[]string{"worker"},
+	)
+	workerBackpressureSeconds = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+		Name: metrics.FQName("event_worker_backpressure_seconds_total"),
+		Help: "Total time in seconds that the stream consumer was blocked waiting for space in the queue of each event processing worker",
+	},
+		[]string{"worker"},
+	)
+)
+
+type workerEvent struct {
+	evt data.Event
+	raw []byte
+}
+
+// eventWorkers is a pool of goroutines for processing events in parallel. The
+// events are partitioned by stream ID so the events of a given stream are
+// always processed in order by the same worker.
+//
+// The worker queues are bounded and Dispatch blocks when the queue is full,
+// which in turn blocks the stream consumer from reading more messages.
+type eventWorkers struct {
+	lock   sync.Mutex
+	closed bool
+	queues []chan workerEvent
+
+	// pending counts the events dispatched but not processed yet
+	pending sync.WaitGroup
+	running sync.WaitGroup
+}
+
+func newEventWorkers(count, queueSize int, process func(evt data.Event, raw []byte)) *eventWorkers {
+	if count < 1 {
+		count = 1
+	}
+	w := &eventWorkers{queues: make([]chan workerEvent, count)}
+	w.running.Add(count)
+	for i := range w.queues {
+		queue := make(chan workerEvent, queueSize)
+		w.queues[i] = queue
+		go w.runWorker(strconv.Itoa(i), queue, process)
+	}
+	return w
+}
+
+func (w *eventWorkers) runWorker(name string, queue <-chan workerEvent, process func(evt data.Event, raw []byte)) {
+	defer w.running.Done()
+	var (
+		queueLength = workerQueueLength.WithLabelValues(name)
+		processed   = workerEventsProcessed.WithLabelValues(name)
+	)
+	for item := range queue {
+		queueLength.Set(float64(len(queue)))
+		process(item.evt, item.raw)
+		processed.Inc()
+		w.pending.Done()
+	}
+}
+
+// Dispatch sends the events to their respective workers, blocking if the queues
+// are full. Returns false if the workers have already been closed, in which
+// case no event is dispatched.
+func (w *eventWorkers) Dispatch(events []workerEvent) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return false
+	}
+	for _, item := range events {
+		idx := workerIdx(item.evt.StreamID(), len(w.queues))
+		queue := w.queues[idx]
+
+		w.pending.Add(1)
+		select {
+		case queue <- item:
+		default:
+			start := time.Now()
+			queue <- item
+			workerBackpressureSeconds.WithLabelValues(strconv.Itoa(idx)).
+				Add(time.Since(start).Seconds())
+		}
+		workerQueueLength.WithLabelValues(strconv.Itoa(idx)).Set(float64(len(queue)))
+	}
+	return true
+}
+
+// WaitPending blocks until all the dispatched events have been processed. The
+// caller must make sure no events are dispatched concurrently.
+func (w *eventWorkers) WaitPending() {
+	w.pending.Wait()
+}
+
+// Close stops accepting new events and waits until the workers finish
+// processing the ones already in their queues.
+func (w *eventWorkers) Close() {
+	w.lock.Lock()
+	if !w.closed {
+		w.closed = true
+		for _, queue := range w.queues {
+			close(queue)
+		}
+	}
+	w.lock.Unlock()
+	w.running.Wait()
+}
+
+func workerIdx(streamID string, count int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(streamID))
+	return int(hash.Sum32() % uint32(count))
+}
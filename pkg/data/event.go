@@ -49,3 +49,38 @@ func (b *Base) StreamID() string {
 func (b *Base) Timestamp() time.Time {
 	return b.Timestamp_.Time
 }
+
+// EventSessionID returns the ID of the stream session that the event belongs
+// to, or an empty string if the event has no session information. Events from
+// older nodes might not have a session ID even if their type supports it.
+func EventSessionID(evt Event) string {
+	switch evt := evt.(type) {
+	case *StreamStateEvent:
+		return evt.SessionID
+	case *TranscodeEvent:
+		return evt.SessionID
+	case *MediaServerMetricsEvent:
+		return evt.SessionID
+	case *WebhookEvent:
+		return evt.SessionID
+	case *HealthTransitionEvent:
+		return evt.SessionID
+	default:
+		return ""
+	}
+}
+
+// EventNode returns the ID and region of the node that sent the event, which
+// are empty if the event type doesn't have them.
+func EventNode(evt Event) (nodeID, region string) {
+	switch evt := evt.(type) {
+	case *StreamStateEvent:
+		return evt.NodeID, evt.Region
+	case *TranscodeEvent:
+		return evt.NodeID, ""
+	case *MediaServerMetricsEvent:
+		return evt.NodeID, evt.Region
+	default:
+		return "", ""
+	}
+}
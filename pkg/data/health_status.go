@@ -3,6 +3,8 @@ package data
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/livepeer/livepeer-data/stats"
@@ -17,6 +19,7 @@ import (
 // if you want to do any mutations to them.
 type HealthStatus struct {
 	ID          string               `json:"id"`
+	SessionID   string               `json:"sessionId,omitempty"`
 	Healthy     *Condition           `json:"healthy"`
 	Conditions  []*Condition         `json:"conditions"`
 	Metrics     MetricsMap           `json:"metrics,omitempty"`
@@ -26,6 +29,10 @@ type HealthStatus struct {
 type MultistreamStatus struct {
 	Target    MultistreamTargetInfo `json:"target"`
 	Connected *Condition            `json:"connected"`
+	// Stalled is whether the target stopped making progress while connected,
+	// from the bytes and media time sent to it. Nil until there are metrics for
+	// the target.
+	Stalled *Condition `json:"stalled,omitempty"`
 }
 
 type ConditionType string
@@ -47,8 +54,8 @@ type Metric struct {
 	Name       MetricName        `json:"name"`
 	Dimensions map[string]string `json:"dimensions,omitempty"`
 	Last       Measure           `json:"last"`
-	// TODO: Implement some historic `stats` here like for conditions. Or maybe
-	// remove the stats from conditions if we end up never needing them.
+	// Stats are summaries of the recent values of the metric by time window.
+	Stats stats.SummaryByWindow `json:"stats,omitempty"`
 }
 
 type Measure struct {
@@ -78,6 +85,9 @@ func NewMergedHealthStatus(base *HealthStatus, values HealthStatus) *HealthStatu
 	if values.ID != "" {
 		new.ID = values.ID
 	}
+	if values.SessionID != "" {
+		new.SessionID = values.SessionID
+	}
 	if values.Healthy != nil {
 		new.Healthy = values.Healthy
 	}
@@ -178,6 +188,16 @@ func NewMetric(name MetricName, dimensions map[string]string, ts time.Time, valu
 	}
 }
 
+// Key returns a string that uniquely identifies the metric name and dimensions.
+func (m *Metric) Key() string {
+	dims := make([]string, 0, len(m.Dimensions))
+	for k, v := range m.Dimensions {
+		dims = append(dims, k+"="+v)
+	}
+	sort.Strings(dims)
+	return string(m.Name) + "{" + strings.Join(dims, ",") + "}"
+}
+
 func (m *Metric) Matches(name MetricName, odim map[string]string) bool {
 	if name != m.Name || len(odim) != len(m.Dimensions) {
 		return false
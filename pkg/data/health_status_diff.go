@@ -0,0 +1,118 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// DiffHealthStatus returns a JSON merge patch (RFC 7396) that transforms the
+// JSON of the old status into the JSON of the new one, or nil if they are the
+// same. A nil old status produces a patch with the whole new status.
+//
+// Merge patches replace arrays entirely and can't differentiate null values
+// from removed fields, so applying the patch might remove fields that were
+// null in the new status instead of setting them to null.
+func DiffHealthStatus(old, new *HealthStatus) (json.RawMessage, error) {
+	var (
+		oldJSON = []byte("{}")
+		err     error
+	)
+	if old != nil {
+		if oldJSON, err = json.Marshal(old); err != nil {
+			return nil, fmt.Errorf("error marshalling old status: %w", err)
+		}
+	}
+	newJSON, err := json.Marshal(new)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling new status: %w", err)
+	}
+	return CreateMergePatch(oldJSON, newJSON)
+}
+
+// CreateMergePatch returns the JSON merge patch (RFC 7396) that transforms the
+// original document into the modified one, or nil if they are equal.
+func CreateMergePatch(original, modified []byte) (json.RawMessage, error) {
+	origValue, err := decodeJSONValue(original)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding original document: %w", err)
+	}
+	modValue, err := decodeJSONValue(modified)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding modified document: %w", err)
+	}
+	patch, changed := createMergePatch(origValue, modValue)
+	if !changed {
+		return nil, nil
+	}
+	return json.Marshal(patch)
+}
+
+// ApplyMergePatch applies a JSON merge patch (RFC 7396) to the document.
+func ApplyMergePatch(doc, patch []byte) (json.RawMessage, error) {
+	docValue, err := decodeJSONValue(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding document: %w", err)
+	}
+	patchValue, err := decodeJSONValue(patch)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding patch: %w", err)
+	}
+	return json.Marshal(applyMergePatch(docValue, patchValue))
+}
+
+func createMergePatch(original, modified interface{}) (interface{}, bool) {
+	origObj, origIsObj := original.(map[string]interface{})
+	modObj, modIsObj := modified.(map[string]interface{})
+	if !origIsObj || !modIsObj {
+		return modified, !reflect.DeepEqual(original, modified)
+	}
+
+	patch := map[string]interface{}{}
+	for key, modValue := range modObj {
+		origValue, ok := origObj[key]
+		if !ok {
+			patch[key] = modValue
+		} else if valuePatch, changed := createMergePatch(origValue, modValue); changed {
+			patch[key] = valuePatch
+		}
+	}
+	for key := range origObj {
+		if _, ok := modObj[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	return patch, len(patch) > 0
+}
+
+func applyMergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = applyMergePatch(targetObj[key], value)
+		}
+	}
+	return targetObj
+}
+
+// decodeJSONValue decodes numbers as json.Number so they are re-encoded
+// exactly as they were.
+func decodeJSONValue(raw []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateMergePatch(t *testing.T) {
+	require := require.New(t)
+	original := []byte(`{"a":"b","c":{"d":"e","f":"g"},"h":[1,2],"i":1.5}`)
+	modified := []byte(`{"a":"z","c":{"d":"e"},"h":[1,2,3],"i":1.5,"j":true}`)
+
+	patch, err := CreateMergePatch(original, modified)
+	require.NoError(err)
+	require.JSONEq(`{"a":"z","c":{"f":null},"h":[1,2,3],"j":true}`, string(patch))
+
+	applied, err := ApplyMergePatch(original, patch)
+	require.NoError(err)
+	require.JSONEq(string(modified), string(applied))
+
+	patch, err = CreateMergePatch(original, original)
+	require.NoError(err)
+	require.Nil(patch)
+}
+
+func TestDiffHealthStatus(t *testing.T) {
+	require := require.New(t)
+	ts := time.Unix(1700000000, 0)
+	active, inactive := true, false
+	old := NewHealthStatus("stream", []*Condition{NewCondition("Active", ts, &inactive, nil)})
+	old.Metrics.Add(NewMetric("ViewerCount", nil, ts, 1))
+
+	conditions := old.ConditionsCopy()
+	conditions[0] = NewCondition("Active", ts.Add(time.Second), &active, conditions[0])
+	new := NewMergedHealthStatus(old, HealthStatus{Conditions: conditions})
+
+	patch, err := DiffHealthStatus(old, new)
+	require.NoError(err)
+	var patchObj map[string]interface{}
+	require.NoError(json.Unmarshal(patch, &patchObj))
+	require.Contains(patchObj, "conditions")
+	require.NotContains(patchObj, "metrics")
+	require.NotContains(patchObj, "healthy")
+
+	oldJSON, err := json.Marshal(old)
+	require.NoError(err)
+	newJSON, err := json.Marshal(new)
+	require.NoError(err)
+	applied, err := ApplyMergePatch(oldJSON, patch)
+	require.NoError(err)
+	require.JSONEq(string(newJSON), string(applied))
+
+	patch, err = DiffHealthStatus(new, new)
+	require.NoError(err)
+	require.Nil(patch)
+}
@@ -0,0 +1,31 @@
+package data
+
+import "time"
+
+const EventTypeHealthTransition EventType = "health_transition"
+
+// ConditionHealthy is the condition type used in health transition events to
+// refer to the Healthy condition of a HealthStatus, which has no type itself.
+const ConditionHealthy ConditionType = "Healthy"
+
+func NewHealthTransitionEvent(streamID, sessionID string, condition ConditionType, previous, status *bool, transitionTime time.Time) *HealthTransitionEvent {
+	return &HealthTransitionEvent{
+		Base:           newEventBase(EventTypeHealthTransition, streamID),
+		SessionID:      sessionID,
+		Condition:      condition,
+		PreviousStatus: previous,
+		Status:         status,
+		TransitionTime: UnixMillisTime{transitionTime},
+	}
+}
+
+// HealthTransitionEvent is sent when the status of a condition of a stream
+// changes, or when its Healthy condition does (see ConditionHealthy).
+type HealthTransitionEvent struct {
+	Base
+	SessionID      string         `json:"sessionId,omitempty"`
+	Condition      ConditionType  `json:"condition"`
+	PreviousStatus *bool          `json:"previousStatus"`
+	Status         *bool          `json:"status"`
+	TransitionTime UnixMillisTime `json:"transitionTime"`
+}
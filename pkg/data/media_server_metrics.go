@@ -16,12 +16,18 @@ type MediaServerMetricsEvent struct {
 	Base
 	NodeID      string                      `json:"nodeId"`
 	Region      string                      `json:"region,omitempty"`
+	SessionID   string                      `json:"sessionId,omitempty"`
 	Stats       *StreamMetrics              `json:"stats"`
 	Multistream []*MultistreamTargetMetrics `json:"multistream"`
 }
 
 type StreamMetrics struct {
 	MediaTimeMs *int64 `json:"mediaTimeMs"`
+	// ViewerCount is the count of unique viewers currently watching the stream
+	// on the node, while SessionCount is the count of playback sessions which
+	// can be more than one per viewer.
+	ViewerCount  *int64 `json:"viewerCount"`
+	SessionCount *int64 `json:"sessionCount"`
 }
 
 type MultistreamTargetMetrics struct {
@@ -53,6 +53,18 @@ func ParseEvent(data []byte) (Event, error) {
 			return nil, fmt.Errorf("error unmarshalling task partial result event: %w", err)
 		}
 		return event, nil
+	case EventTypeHealthTransition:
+		var event *HealthTransitionEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("error unmarshalling health transition event: %w", err)
+		}
+		return event, nil
+	case EventTypeSubscriptionGap:
+		var event *SubscriptionGapEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("error unmarshalling subscription gap event: %w", err)
+		}
+		return event, nil
 	default:
 		return nil, fmt.Errorf("unknown event type=%q, streamId=%q, ts=%v", base.Type(), base.StreamID_, base.Timestamp_)
 	}
@@ -14,10 +14,11 @@ func NewStreamStateEvent(nodeID, region, userID, streamID string, state StreamSt
 
 type StreamStateEvent struct {
 	Base
-	NodeID string      `json:"nodeId"`
-	Region string      `json:"region,omitempty"`
-	UserID string      `json:"userId"`
-	State  StreamState `json:"state"`
+	NodeID    string      `json:"nodeId"`
+	Region    string      `json:"region,omitempty"`
+	UserID    string      `json:"userId"`
+	SessionID string      `json:"sessionId,omitempty"`
+	State     StreamState `json:"state"`
 }
 
 type StreamState struct {
@@ -0,0 +1,26 @@
+package data
+
+import "github.com/google/uuid"
+
+const EventTypeSubscriptionGap EventType = "subscription_gap"
+
+func NewSubscriptionGapEvent(streamID string, lastEventID *uuid.UUID, lastDroppedEventID uuid.UUID, droppedEvents int) *SubscriptionGapEvent {
+	return &SubscriptionGapEvent{
+		Base:               newEventBase(EventTypeSubscriptionGap, streamID),
+		LastEventID:        lastEventID,
+		LastDroppedEventID: lastDroppedEventID,
+		DroppedEvents:      droppedEvents,
+	}
+}
+
+// SubscriptionGapEvent is a synthetic event sent to an event subscription when
+// events were dropped because its buffer was full. The missed events can be
+// retrieved by resuming from LastEventID, which is the ID of the last event
+// delivered before the gap or nil if none was. LastDroppedEventID is the ID of
+// the last missed event, which the gap stands for when resuming after it.
+type SubscriptionGapEvent struct {
+	Base
+	LastEventID        *uuid.UUID `json:"lastEventId,omitempty"`
+	LastDroppedEventID uuid.UUID  `json:"lastDroppedEventId"`
+	DroppedEvents      int        `json:"droppedEvents"`
+}
@@ -0,0 +1,66 @@
+package data
+
+type TaskPhase string
+
+const (
+	TaskPhaseRunning   TaskPhase = "running"
+	TaskPhaseCompleted TaskPhase = "completed"
+	TaskPhaseFailed    TaskPhase = "failed"
+)
+
+// TaskStatus is the lifecycle of a task, built from its trigger, partial
+// result and result events. Like HealthStatus, it is soft-immutable and must
+// be copied to be mutated.
+type TaskStatus struct {
+	ID    string    `json:"id"`
+	Type  string    `json:"type"`
+	Phase TaskPhase `json:"phase"`
+	// Step is the name of the current step, or the last one if finished.
+	Step  string     `json:"step"`
+	Steps []TaskStep `json:"steps"`
+	// Retries is the count of times the task was triggered again after failing
+	// with a retriable error.
+	Retries   int             `json:"retries,omitempty"`
+	StartTime UnixMillisTime  `json:"startTime"`
+	UpdatedAt UnixMillisTime  `json:"updatedAt"`
+	EndTime   *UnixMillisTime `json:"endTime,omitempty"`
+
+	PartialOutput *TaskPartialOutput `json:"partialOutput,omitempty"`
+	Output        *TaskOutput        `json:"output,omitempty"`
+	Error         *ErrorInfo         `json:"error,omitempty"`
+}
+
+// TaskStep is the execution of a single step of a task, from its trigger until
+// the next trigger or the task result.
+type TaskStep struct {
+	Name      string          `json:"name"`
+	StartTime UnixMillisTime  `json:"startTime"`
+	EndTime   *UnixMillisTime `json:"endTime,omitempty"`
+	// DurationMs is only set once the step has ended.
+	DurationMs int64 `json:"durationMs,omitempty"`
+}
+
+func (s *TaskStatus) Finished() bool {
+	return s.Phase == TaskPhaseCompleted || s.Phase == TaskPhaseFailed
+}
+
+func (s *TaskStatus) StepsCopy() []TaskStep {
+	steps := make([]TaskStep, len(s.Steps))
+	copy(steps, s.Steps)
+	return steps
+}
+
+// TaskEventInfo returns the task that the event refers to, if it is an event
+// of the lifecycle of a task.
+func TaskEventInfo(evt Event) (TaskInfo, bool) {
+	switch evt := evt.(type) {
+	case *TaskTriggerEvent:
+		return evt.Task, true
+	case *TaskResultPartialEvent:
+		return evt.Task, true
+	case *TaskResultEvent:
+		return evt.Task, true
+	default:
+		return TaskInfo{}, false
+	}
+}
@@ -22,6 +22,7 @@ func NewTranscodeEvent(nodeID, streamID string, seg SegmentMetadata, startTime t
 type TranscodeEvent struct {
 	Base
 	NodeID    string                 `json:"nodeId"`
+	SessionID string                 `json:"sessionId,omitempty"`
 	Segment   SegmentMetadata        `json:"segment"`
 	StartTime UnixMillisTime         `json:"startTime"`
 	LatencyMs int64                  `json:"latencyMs"`
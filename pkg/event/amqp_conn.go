@@ -29,8 +29,13 @@ type AMQPChanSetup interface {
 
 type AMQPConnectFunc func(ctx context.Context, uri string, confirms chan amqp.Confirmation, closed chan *amqp.Error) (AMQPChanOps, error)
 
+// NewAMQPConnectFunc returns an AMQPConnectFunc for dialing a RabbitMQ server,
+// or connecting to an in-process MemoryBroker in case of a memory:// URI.
 func NewAMQPConnectFunc(setup func(c AMQPChanSetup) error) AMQPConnectFunc {
 	return func(ctx context.Context, uri string, confirms chan amqp.Confirmation, closed chan *amqp.Error) (AMQPChanOps, error) {
+		if broker, ok := memoryBrokerFromUri(uri); ok {
+			return NewMemoryAMQPConnectFunc(broker, setup)(ctx, uri, confirms, closed)
+		}
 		conn, err := amqp.Dial(uri)
 		if err != nil {
 			return nil, fmt.Errorf("dial: %w", err)
@@ -0,0 +1,154 @@
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+
+	"github.com/golang/glog"
+	streamAmqp "github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+)
+
+const maxFileLineSize = 4 * 1024 * 1024
+
+// FileStreamConsumer is a StreamConsumer that reads messages from JSONL files
+// instead of a RabbitMQ stream, one message per line. It is meant for replaying
+// recorded events offline, so the stream name, bindings and offset in the
+// consume options are all ignored and every file is consumed from the start.
+//
+// The special path "-" reads from stdin.
+type FileStreamConsumer struct {
+	paths []string
+
+	consumeOnce sync.Once
+	done        chan struct{}
+}
+
+var _ StreamConsumer = (*FileStreamConsumer)(nil)
+
+func NewFileStreamConsumer(paths ...string) *FileStreamConsumer {
+	return &FileStreamConsumer{paths: paths, done: make(chan struct{})}
+}
+
+// Done returns a channel that is closed when all the files have been consumed,
+// and all messages handled in case of Consume.
+func (c *FileStreamConsumer) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *FileStreamConsumer) ConsumeChan(ctx context.Context, _ ConsumeOptions) (<-chan StreamMessage, error) {
+	msgs, err := c.consumeChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan StreamMessage, 100)
+	go func() {
+		defer close(c.done)
+		defer close(out)
+		for msg := range msgs {
+			out <- msg
+		}
+	}()
+	return out, nil
+}
+
+func (c *FileStreamConsumer) Consume(ctx context.Context, _ ConsumeOptions, handler Handler) error {
+	msgs, err := c.consumeChan(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer close(c.done)
+		handleMsgRecv := func(msg StreamMessage) {
+			defer func() {
+				if rec := recover(); rec != nil {
+					glog.Fatalf("Panic in file message handler. panicValue=%q message=%q stack=%q", rec, msg.Data, debug.Stack())
+				}
+			}()
+
+			handler.HandleMessage(msg)
+		}
+
+		for msg := range msgs {
+			handleMsgRecv(msg)
+		}
+	}()
+	return nil
+}
+
+func (c *FileStreamConsumer) consumeChan(ctx context.Context) (<-chan StreamMessage, error) {
+	started := false
+	c.consumeOnce.Do(func() { started = true })
+	if !started {
+		return nil, errors.New("file consumer can only be consumed once")
+	}
+	for _, path := range c.paths {
+		if path == "-" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("error opening events file: %w", err)
+		}
+	}
+
+	msgs := make(chan StreamMessage, 100)
+	go func() {
+		defer close(msgs)
+		for _, path := range c.paths {
+			if err := readFileMessages(ctx, path, msgs); err != nil {
+				glog.Errorf("Error reading events file. path=%q, err=%q", path, err)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	return msgs, nil
+}
+
+func readFileMessages(ctx context.Context, path string, msgs chan<- StreamMessage) error {
+	var reader io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxFileLineSize)
+	lines := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		lines++
+		// the scanner reuses its buffer so we need to copy the line
+		data := append([]byte(nil), line...)
+		select {
+		case msgs <- StreamMessage{Message: &streamAmqp.Message{Data: [][]byte{data}}}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	glog.Infof("Finished reading events file. path=%q, lines=%d", path, lines)
+	return scanner.Err()
+}
+
+func (c *FileStreamConsumer) CheckConnection() error {
+	return nil
+}
+
+func (c *FileStreamConsumer) Close() error {
+	return nil
+}
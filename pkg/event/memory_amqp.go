@@ -0,0 +1,345 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// NewMemoryAMQPConnectFunc returns an AMQPConnectFunc for connecting to the
+// given in-process broker. The URI passed to the returned function is ignored.
+func NewMemoryAMQPConnectFunc(broker *MemoryBroker, setup func(c AMQPChanSetup) error) AMQPConnectFunc {
+	return func(ctx context.Context, _ string, confirms chan amqp.Confirmation, closed chan *amqp.Error) (AMQPChanOps, error) {
+		channel := newMemoryChannel(ctx, broker, confirms, closed)
+		if setup != nil {
+			if err := setup(channel); err != nil {
+				channel.close()
+				return nil, fmt.Errorf("channel setup: %w", err)
+			}
+		}
+		return channel, nil
+	}
+}
+
+// memoryChannel implements the AMQP channel operations on top of a
+// MemoryBroker. It is always in confirm mode and lives until the connection
+// context is done, like the channels from NewAMQPConnectFunc.
+type memoryChannel struct {
+	broker *MemoryBroker
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock            sync.Mutex
+	closed          bool
+	nextPublishTag  uint64
+	nextDeliveryTag uint64
+	unacked         map[uint64]memoryUnacked
+	confirms        []amqp.Confirmation
+	// confirmsNotify is closed and replaced when confirmations are added
+	confirmsNotify chan struct{}
+}
+
+type memoryUnacked struct {
+	queue *memoryQueue
+	msg   amqp.Delivery
+}
+
+var (
+	_ AMQPChanOps       = (*memoryChannel)(nil)
+	_ AMQPChanSetup     = (*memoryChannel)(nil)
+	_ amqp.Acknowledger = (*memoryChannel)(nil)
+)
+
+func newMemoryChannel(ctx context.Context, broker *MemoryBroker, confirms chan amqp.Confirmation, closed chan *amqp.Error) *memoryChannel {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &memoryChannel{
+		broker:          broker,
+		ctx:             ctx,
+		cancel:          cancel,
+		nextPublishTag:  1,
+		nextDeliveryTag: 1,
+		unacked:         map[uint64]memoryUnacked{},
+		confirmsNotify:  make(chan struct{}),
+	}
+	go c.forwardConfirms(confirms)
+	go func() {
+		<-ctx.Done()
+		c.close()
+		// like the amqp lib, notify graceful closes by closing the channel
+		if closed != nil {
+			close(closed)
+		}
+	}()
+	return c
+}
+
+// forwardConfirms sends the publish confirmations asynchronously so a slow
+// reader of the confirms channel never blocks publishing.
+func (c *memoryChannel) forwardConfirms(confirms chan amqp.Confirmation) {
+	if confirms == nil {
+		return
+	}
+	defer close(confirms)
+	for {
+		c.lock.Lock()
+		pending, notify := c.confirms, c.confirmsNotify
+		c.confirms = nil
+		c.lock.Unlock()
+
+		for _, conf := range pending {
+			select {
+			case confirms <- conf:
+			case <-c.ctx.Done():
+				return
+			}
+		}
+		if len(pending) > 0 {
+			continue
+		}
+		select {
+		case <-notify:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// close requeues all the messages delivered but not acknowledged, like the
+// broker does when a channel is closed.
+func (c *memoryChannel) close() {
+	c.cancel()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for tag, unacked := range c.unacked {
+		c.requeueLocked(tag, unacked)
+	}
+}
+
+func (c *memoryChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if immediate {
+		return memoryAmqpError(amqp.NotImplemented, "immediate=true")
+	}
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	routed, err := c.broker.publish(exchange, key, msg)
+	if err != nil {
+		return err
+	}
+	if !routed && mandatory {
+		// RabbitMQ returns unroutable mandatory messages but still acks them.
+		glog.Warningf("Unroutable mandatory message published to in-memory broker. exchange=%q key=%q", exchange, key)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.confirms = append(c.confirms, amqp.Confirmation{DeliveryTag: c.nextPublishTag, Ack: true})
+	c.nextPublishTag++
+	close(c.confirmsNotify)
+	c.confirmsNotify = make(chan struct{})
+	return nil
+}
+
+func (c *memoryChannel) Consume(queueName, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	if err := c.checkOpen(); err != nil {
+		return nil, err
+	}
+	c.broker.lock.Lock()
+	queue, ok := c.broker.queues[queueName]
+	c.broker.lock.Unlock()
+	if !ok {
+		return nil, memoryAmqpError(amqp.NotFound, "no queue %q", queueName)
+	}
+
+	deliveries := make(chan amqp.Delivery)
+	queue.addConsumer(1)
+	go func() {
+		defer close(deliveries)
+		defer queue.addConsumer(-1)
+		for {
+			msg, ok := queue.pop(c.ctx)
+			if !ok {
+				return
+			}
+			msg.ConsumerTag = consumer
+			tag, ok := c.track(queue, msg, autoAck)
+			if !ok {
+				queue.push(msg, true)
+				return
+			}
+			msg.DeliveryTag = tag
+			if !autoAck {
+				msg.Acknowledger = c
+			}
+
+			select {
+			case deliveries <- msg:
+			case <-c.ctx.Done():
+				// the message is requeued on close
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+func (c *memoryChannel) track(queue *memoryQueue, msg amqp.Delivery, autoAck bool) (uint64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return 0, false
+	}
+	tag := c.nextDeliveryTag
+	c.nextDeliveryTag++
+	if !autoAck {
+		msg.DeliveryTag = tag
+		c.unacked[tag] = memoryUnacked{queue, msg}
+	}
+	return tag, true
+}
+
+func (c *memoryChannel) Ack(tag uint64, multiple bool) error {
+	return c.settle(tag, multiple, false)
+}
+
+func (c *memoryChannel) Nack(tag uint64, multiple, requeue bool) error {
+	return c.settle(tag, multiple, requeue)
+}
+
+func (c *memoryChannel) Reject(tag uint64, requeue bool) error {
+	return c.settle(tag, false, requeue)
+}
+
+func (c *memoryChannel) settle(tag uint64, multiple, requeue bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	if _, ok := c.unacked[tag]; !ok {
+		return memoryAmqpError(amqp.PreconditionFailed, "unknown delivery tag %d", tag)
+	}
+	for unackedTag, unacked := range c.unacked {
+		if unackedTag != tag && (!multiple || unackedTag > tag) {
+			continue
+		}
+		delete(c.unacked, unackedTag)
+		if requeue {
+			c.requeueLocked(unackedTag, unacked)
+		}
+	}
+	return nil
+}
+
+func (c *memoryChannel) requeueLocked(tag uint64, unacked memoryUnacked) {
+	delete(c.unacked, tag)
+	msg := unacked.msg
+	msg.Acknowledger, msg.DeliveryTag, msg.ConsumerTag = nil, 0, ""
+	msg.Redelivered = true
+	unacked.queue.push(msg, true)
+}
+
+func (c *memoryChannel) checkOpen() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	return nil
+}
+
+func (c *memoryChannel) ExchangeBind(destination, key, source string, noWait bool, args amqp.Table) error {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.broker.bindLocked(destination, key, source, true)
+}
+
+func (c *memoryChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.broker.declareExchangeLocked(name, kind, false)
+}
+
+func (c *memoryChannel) ExchangeDeclarePassive(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.broker.declareExchangeLocked(name, kind, true)
+}
+
+func (c *memoryChannel) ExchangeDelete(name string, ifUnused, noWait bool) error {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	exchange, ok := c.broker.exchanges[name]
+	if !ok {
+		return memoryAmqpError(amqp.NotFound, "no exchange %q", name)
+	} else if ifUnused && len(exchange.bindings) > 0 {
+		return memoryAmqpError(amqp.PreconditionFailed, "exchange %q in use", name)
+	}
+	delete(c.broker.exchanges, name)
+	c.broker.unbindAllLocked(name, true)
+	return nil
+}
+
+func (c *memoryChannel) ExchangeUnbind(destination, key, source string, noWait bool, args amqp.Table) error {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.broker.unbindLocked(destination, key, source, true)
+}
+
+func (c *memoryChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.broker.bindLocked(name, key, exchange, false)
+}
+
+func (c *memoryChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.broker.declareQueueLocked(name, args, false)
+}
+
+func (c *memoryChannel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.broker.declareQueueLocked(name, args, true)
+}
+
+func (c *memoryChannel) QueueDelete(name string, ifUnused, ifEmpty, noWait bool) (int, error) {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	if queue, ok := c.broker.queues[name]; ok {
+		info := queue.info()
+		if ifUnused && info.Consumers > 0 {
+			return 0, memoryAmqpError(amqp.PreconditionFailed, "queue %q in use", name)
+		} else if ifEmpty && info.Messages > 0 {
+			return 0, memoryAmqpError(amqp.PreconditionFailed, "queue %q not empty", name)
+		}
+	}
+	return c.broker.deleteQueueLocked(name)
+}
+
+func (c *memoryChannel) QueueInspect(name string) (amqp.Queue, error) {
+	return c.QueueDeclarePassive(name, false, false, false, false, nil)
+}
+
+func (c *memoryChannel) QueueUnbind(name, key, exchange string, args amqp.Table) error {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	return c.broker.unbindLocked(name, key, exchange, false)
+}
+
+func (c *memoryChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	if prefetchCount < 0 || prefetchSize < 0 {
+		return errors.New("qos: negative prefetch")
+	}
+	// messages are delivered one at a time, so prefetch is not emulated.
+	return nil
+}
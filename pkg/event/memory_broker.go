@@ -0,0 +1,446 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryBrokerScheme is the URI scheme for connecting to an in-process broker
+// instead of a real RabbitMQ. URIs like memory://<name> can be used anywhere a
+// RabbitMQ URI is expected by NewStreamConsumer and NewAMQPConnectFunc, and
+// all URIs with the same name connect to the same broker.
+const MemoryBrokerScheme = "memory"
+
+var (
+	memoryBrokersLock sync.Mutex
+	memoryBrokers     = map[string]*MemoryBroker{}
+)
+
+// MemoryBroker is an in-process message broker emulating the subset of
+// RabbitMQ used by this package: exchanges with direct, fanout and topic
+// routing, queues for AMQP consumers and streams for stream consumers. It is
+// meant for local development and tests only, so messages are only kept in
+// memory and stream retention options are ignored.
+type MemoryBroker struct {
+	lock      sync.Mutex
+	exchanges map[string]*memoryExchange
+	queues    map[string]*memoryQueue
+	streams   map[string]*memoryStream
+}
+
+type memoryExchange struct {
+	name, kind string
+	bindings   []memoryBinding
+}
+
+type memoryBinding struct {
+	key, destination string
+	toExchange       bool
+}
+
+// GetMemoryBroker returns the in-process broker with the given name, creating
+// it if it doesn't exist yet.
+func GetMemoryBroker(name string) *MemoryBroker {
+	memoryBrokersLock.Lock()
+	defer memoryBrokersLock.Unlock()
+	broker, ok := memoryBrokers[name]
+	if !ok {
+		broker = NewMemoryBroker()
+		memoryBrokers[name] = broker
+	}
+	return broker
+}
+
+// NewMemoryBroker creates a standalone in-process broker, not reachable
+// through memory:// URIs.
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		exchanges: map[string]*memoryExchange{},
+		queues:    map[string]*memoryQueue{},
+		streams:   map[string]*memoryStream{},
+	}
+}
+
+func memoryBrokerFromUri(uriStr string) (*MemoryBroker, bool) {
+	uri, err := url.Parse(uriStr)
+	if err != nil || uri.Scheme != MemoryBrokerScheme {
+		return nil, false
+	}
+	return GetMemoryBroker(uri.Host), true
+}
+
+// DeclareExchange creates an exchange of the given kind if it doesn't exist.
+func (b *MemoryBroker) DeclareExchange(name, kind string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.declareExchangeLocked(name, kind, false)
+}
+
+// DeclareStream creates a stream if it doesn't exist. Streams are also queues
+// in RabbitMQ, so they can be bound to exchanges and published to through the
+// default exchange.
+func (b *MemoryBroker) DeclareStream(name string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.declareStreamLocked(name)
+}
+
+func (b *MemoryBroker) declareExchangeLocked(name, kind string, passive bool) error {
+	if name == "" || strings.HasPrefix(name, "amq.") {
+		return memoryAmqpError(amqp.AccessRefused, "exchange name %q is reserved", name)
+	}
+	if exchange, ok := b.exchanges[name]; ok {
+		if exchange.kind != kind {
+			return memoryAmqpError(amqp.PreconditionFailed, "inequivalent kind for exchange %q: %q != %q", name, kind, exchange.kind)
+		}
+		return nil
+	} else if passive {
+		return memoryAmqpError(amqp.NotFound, "no exchange %q", name)
+	}
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic:
+	default:
+		return memoryAmqpError(amqp.NotImplemented, "unsupported exchange kind %q", kind)
+	}
+	b.exchanges[name] = &memoryExchange{name: name, kind: kind}
+	return nil
+}
+
+func (b *MemoryBroker) declareQueueLocked(name string, args amqp.Table, passive bool) (amqp.Queue, error) {
+	if name == "" {
+		if passive {
+			return amqp.Queue{}, memoryAmqpError(amqp.NotFound, "no queue with empty name")
+		}
+		name = "amq.gen-" + uuid.NewString()
+	}
+	if stream, ok := b.streams[name]; ok {
+		return amqp.Queue{Name: name, Messages: stream.length()}, nil
+	} else if queue, ok := b.queues[name]; ok {
+		return queue.info(), nil
+	} else if passive {
+		return amqp.Queue{}, memoryAmqpError(amqp.NotFound, "no queue %q", name)
+	}
+
+	if args["x-queue-type"] == "stream" {
+		b.declareStreamLocked(name)
+		return amqp.Queue{Name: name}, nil
+	}
+	queue := newMemoryQueue(name)
+	b.queues[name] = queue
+	return queue.info(), nil
+}
+
+func (b *MemoryBroker) declareStreamLocked(name string) *memoryStream {
+	stream, ok := b.streams[name]
+	if !ok {
+		stream = newMemoryStream(name)
+		b.streams[name] = stream
+	}
+	return stream
+}
+
+func (b *MemoryBroker) deleteQueueLocked(name string) (int, error) {
+	if stream, ok := b.streams[name]; ok {
+		delete(b.streams, name)
+		b.unbindAllLocked(name, false)
+		return stream.length(), nil
+	} else if queue, ok := b.queues[name]; ok {
+		delete(b.queues, name)
+		b.unbindAllLocked(name, false)
+		return queue.delete(), nil
+	}
+	return 0, memoryAmqpError(amqp.NotFound, "no queue %q", name)
+}
+
+func (b *MemoryBroker) bindLocked(destination, key, source string, toExchange bool) error {
+	exchange, ok := b.exchanges[source]
+	if !ok {
+		return memoryAmqpError(amqp.NotFound, "no exchange %q", source)
+	}
+	if toExchange {
+		if _, ok := b.exchanges[destination]; !ok {
+			return memoryAmqpError(amqp.NotFound, "no exchange %q", destination)
+		}
+	} else if _, ok := b.streams[destination]; !ok {
+		if _, ok := b.queues[destination]; !ok {
+			return memoryAmqpError(amqp.NotFound, "no queue %q", destination)
+		}
+	}
+	binding := memoryBinding{key, destination, toExchange}
+	for _, existing := range exchange.bindings {
+		if existing == binding {
+			return nil
+		}
+	}
+	exchange.bindings = append(exchange.bindings, binding)
+	return nil
+}
+
+func (b *MemoryBroker) unbindLocked(destination, key, source string, toExchange bool) error {
+	exchange, ok := b.exchanges[source]
+	if !ok {
+		return memoryAmqpError(amqp.NotFound, "no exchange %q", source)
+	}
+	binding := memoryBinding{key, destination, toExchange}
+	for i, existing := range exchange.bindings {
+		if existing == binding {
+			exchange.bindings = append(exchange.bindings[:i], exchange.bindings[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) unbindAllLocked(destination string, toExchange bool) {
+	for _, exchange := range b.exchanges {
+		bindings := exchange.bindings[:0]
+		for _, binding := range exchange.bindings {
+			if binding.destination != destination || binding.toExchange != toExchange {
+				bindings = append(bindings, binding)
+			}
+		}
+		exchange.bindings = bindings
+	}
+}
+
+// publish routes the message to all the queues and streams bound to the
+// exchange with a matching key. Returns whether the message was routed to at
+// least one destination.
+func (b *MemoryBroker) publish(exchange, key string, msg amqp.Publishing) (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var destinations []string
+	if exchange == "" {
+		destinations = []string{key}
+	} else {
+		if _, ok := b.exchanges[exchange]; !ok {
+			return false, memoryAmqpError(amqp.NotFound, "no exchange %q", exchange)
+		}
+		destinations = b.routeLocked(exchange, key, map[string]bool{})
+	}
+
+	routed := false
+	for _, dest := range destinations {
+		if stream, ok := b.streams[dest]; ok {
+			stream.append(msg.Body)
+			routed = true
+		} else if queue, ok := b.queues[dest]; ok {
+			queue.push(amqp.Delivery{
+				Headers:         msg.Headers,
+				ContentType:     msg.ContentType,
+				ContentEncoding: msg.ContentEncoding,
+				DeliveryMode:    msg.DeliveryMode,
+				Priority:        msg.Priority,
+				CorrelationId:   msg.CorrelationId,
+				ReplyTo:         msg.ReplyTo,
+				Expiration:      msg.Expiration,
+				MessageId:       msg.MessageId,
+				Timestamp:       msg.Timestamp,
+				Type:            msg.Type,
+				UserId:          msg.UserId,
+				AppId:           msg.AppId,
+				Exchange:        exchange,
+				RoutingKey:      key,
+				Body:            msg.Body,
+			}, false)
+			routed = true
+		}
+	}
+	return routed, nil
+}
+
+func (b *MemoryBroker) routeLocked(exchangeName, key string, visited map[string]bool) []string {
+	exchange, ok := b.exchanges[exchangeName]
+	if !ok || visited[exchangeName] {
+		return nil
+	}
+	visited[exchangeName] = true
+
+	var destinations []string
+	for _, binding := range exchange.bindings {
+		if !routingKeyMatches(exchange.kind, binding.key, key) {
+			continue
+		}
+		if binding.toExchange {
+			destinations = append(destinations, b.routeLocked(binding.destination, key, visited)...)
+		} else {
+			destinations = append(destinations, binding.destination)
+		}
+	}
+	return dedupStrings(destinations)
+}
+
+func routingKeyMatches(kind, bindingKey, key string) bool {
+	switch kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return topicMatches(strings.Split(bindingKey, "."), strings.Split(key, "."))
+	default:
+		return bindingKey == key
+	}
+}
+
+// topicMatches implements the AMQP topic matching, where * matches exactly one
+// word and # matches zero or more words.
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatches(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatches(pattern[1:], words[1:])
+	}
+}
+
+func dedupStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	deduped := values[:0]
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			deduped = append(deduped, value)
+		}
+	}
+	return deduped
+}
+
+func memoryAmqpError(code int, format string, args ...interface{}) *amqp.Error {
+	return &amqp.Error{Code: code, Reason: fmt.Sprintf(format, args...), Server: true}
+}
+
+// memoryQueue is a classic AMQP queue, where each message is delivered to a
+// single consumer.
+type memoryQueue struct {
+	name string
+
+	lock      sync.Mutex
+	messages  []amqp.Delivery
+	consumers int
+	// notify is closed and replaced whenever there are new messages
+	notify  chan struct{}
+	deleted chan struct{}
+}
+
+func newMemoryQueue(name string) *memoryQueue {
+	return &memoryQueue{name: name, notify: make(chan struct{}), deleted: make(chan struct{})}
+}
+
+func (q *memoryQueue) info() amqp.Queue {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return amqp.Queue{Name: q.name, Messages: len(q.messages), Consumers: q.consumers}
+}
+
+func (q *memoryQueue) push(msg amqp.Delivery, front bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if front {
+		q.messages = append([]amqp.Delivery{msg}, q.messages...)
+	} else {
+		q.messages = append(q.messages, msg)
+	}
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+// pop waits for the next message in the queue. Returns false if the context is
+// done or the queue is deleted before any message is available.
+func (q *memoryQueue) pop(ctx context.Context) (amqp.Delivery, bool) {
+	for {
+		q.lock.Lock()
+		if len(q.messages) > 0 {
+			msg := q.messages[0]
+			q.messages = q.messages[1:]
+			q.lock.Unlock()
+			return msg, true
+		}
+		notify := q.notify
+		q.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-q.deleted:
+			return amqp.Delivery{}, false
+		case <-ctx.Done():
+			return amqp.Delivery{}, false
+		}
+	}
+}
+
+func (q *memoryQueue) addConsumer(delta int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.consumers += delta
+}
+
+func (q *memoryQueue) delete() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	count := len(q.messages)
+	q.messages = nil
+	close(q.deleted)
+	return count
+}
+
+// memoryStream is an append-only log of messages which can be read from any
+// offset by any number of consumers.
+type memoryStream struct {
+	name string
+
+	lock     sync.Mutex
+	messages []memoryStreamMessage
+	// notify is closed and replaced whenever there are new messages
+	notify chan struct{}
+}
+
+type memoryStreamMessage struct {
+	data      []byte
+	timestamp time.Time
+}
+
+func newMemoryStream(name string) *memoryStream {
+	return &memoryStream{name: name, notify: make(chan struct{})}
+}
+
+func (s *memoryStream) length() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.messages)
+}
+
+func (s *memoryStream) append(data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = append(s.messages, memoryStreamMessage{data, time.Now()})
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// read returns the messages from the given offset onwards, and a channel that
+// is closed when new messages are appended.
+func (s *memoryStream) read(offset int) ([]memoryStreamMessage, <-chan struct{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if offset >= len(s.messages) {
+		return nil, s.notify
+	}
+	return s.messages[offset:len(s.messages):len(s.messages)], s.notify
+}
@@ -0,0 +1,138 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicMatches(t *testing.T) {
+	require := require.New(t)
+	matches := func(pattern, key string) bool {
+		return routingKeyMatches(amqp.ExchangeTopic, pattern, key)
+	}
+	require.True(matches("stream.state.#", "stream.state"))
+	require.True(matches("stream.state.#", "stream.state.a.b"))
+	require.True(matches("*.state.*", "stream.state.a"))
+	require.False(matches("*.state.*", "stream.state"))
+	require.False(matches("stream.*", "stream.state.a"))
+	require.True(matches("#.a", "a"))
+	require.False(matches("stream.state", "stream.states"))
+}
+
+func TestMemoryBrokerStreamFromExchangeProducer(t *testing.T) {
+	require := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	uri := "memory://" + t.Name()
+	producer, err := NewAMQPExchangeProducer(ctx, uri, "test_exchange", "ns")
+	require.NoError(err)
+	require.NoError(producer.Publish(ctx, "before.bind", "lost", false))
+
+	consumer, err := NewStreamConsumer(uri, "")
+	require.NoError(err)
+	defer consumer.Close()
+	opts := ConsumeOptions{
+		Stream: "test_stream",
+		StreamOptions: &StreamOptions{
+			Bindings: []BindingArgs{{Exchange: "test_exchange", Key: "ns.#"}},
+		},
+		ConsumerOptions: NewConsumerOptions("test", OffsetSpec.First()),
+	}
+	msgs, err := consumer.ConsumeChan(ctx, opts)
+	require.NoError(err)
+
+	for _, body := range []string{"a", "b", "c"} {
+		require.NoError(producer.Publish(ctx, "key", body, false))
+	}
+	for _, expected := range []string{"a", "b", "c"} {
+		require.Equal(expected, readStreamBody(t, msgs))
+	}
+
+	opts.ConsumerOptions = NewConsumerOptions("test", OffsetSpec.Offset(1))
+	msgs, err = consumer.ConsumeChan(ctx, opts)
+	require.NoError(err)
+	require.Equal("b", readStreamBody(t, msgs))
+
+	opts.ConsumerOptions = NewConsumerOptions("test", TimestampOffset(time.Now().Add(-time.Minute)))
+	msgs, err = consumer.ConsumeChan(ctx, opts)
+	require.NoError(err)
+	require.Equal("a", readStreamBody(t, msgs))
+
+	opts.ConsumerOptions = NewConsumerOptions("test", OffsetSpec.Next())
+	msgs, err = consumer.ConsumeChan(ctx, opts)
+	require.NoError(err)
+	require.NoError(producer.Publish(ctx, "key", "d", false))
+	require.Equal("d", readStreamBody(t, msgs))
+}
+
+func TestMemoryBrokerQueueRedelivery(t *testing.T) {
+	require := require.New(t)
+	broker := NewMemoryBroker()
+	connectFn := NewMemoryAMQPConnectFunc(broker, func(c AMQPChanSetup) error {
+		if err := c.ExchangeDeclare("test_exchange", "direct", true, false, false, false, nil); err != nil {
+			return err
+		}
+		if _, err := c.QueueDeclare("test_queue", true, false, false, false, nil); err != nil {
+			return err
+		}
+		return c.QueueBind("test_queue", "key", "test_exchange", false, nil)
+	})
+	client, err := NewAMQPClient("", connectFn)
+	require.NoError(err)
+	defer client.Shutdown(context.Background())
+
+	received := make(chan string, 10)
+	attempts := 0
+	err = client.Consume("test_queue", 1, func(msg amqp.Delivery) error {
+		received <- fmt.Sprintf("%s redelivered=%v", msg.Body, msg.Redelivered)
+		if attempts++; attempts == 2 {
+			return errors.New("requeue me")
+		}
+		return nil
+	})
+	require.NoError(err)
+
+	ctx := context.Background()
+	require.NoError(client.Publish(ctx, AMQPMessage{Exchange: "test_exchange", Key: "key", Body: "first", WaitResult: true}))
+	require.NoError(client.Publish(ctx, AMQPMessage{Exchange: "test_exchange", Key: "key", Body: "second", WaitResult: true}))
+	require.NoError(client.Publish(ctx, AMQPMessage{Exchange: "test_exchange", Key: "other", Body: "unrouted", WaitResult: true}))
+
+	for _, expected := range []string{`"first" redelivered=false`, `"second" redelivered=false`, `"second" redelivered=true`} {
+		select {
+		case body := <-received:
+			require.Equal(expected, body)
+		case <-time.After(5 * time.Second):
+			require.FailNow("timed out waiting for message", expected)
+		}
+	}
+	require.Eventually(func() bool {
+		info, err := queueInfo(broker, "test_queue")
+		return err == nil && info.Messages == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func queueInfo(broker *MemoryBroker, name string) (amqp.Queue, error) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	return broker.declareQueueLocked(name, nil, true)
+}
+
+func readStreamBody(t *testing.T, msgs <-chan StreamMessage) string {
+	select {
+	case msg := <-msgs:
+		var body string
+		require.NoError(t, json.Unmarshal(msg.Data[0], &body))
+		return body
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for stream message")
+		return ""
+	}
+}
@@ -0,0 +1,191 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	amqp "github.com/rabbitmq/amqp091-go"
+	streamAmqp "github.com/rabbitmq/rabbitmq-stream-go-client/pkg/amqp"
+	"github.com/rabbitmq/rabbitmq-stream-go-client/pkg/stream"
+)
+
+// memoryStreamConsumer is a StreamConsumer reading from the streams of an
+// in-process MemoryBroker. The messages are delivered with an empty consumer
+// context, so there is no offset information on them.
+type memoryStreamConsumer struct {
+	broker *MemoryBroker
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+var _ StreamConsumer = (*memoryStreamConsumer)(nil)
+
+// NewMemoryStreamConsumer creates a StreamConsumer for the given in-process
+// broker. The streams are created and bound to exchanges on consume, like for a
+// real RabbitMQ. Differently from RabbitMQ though, missing exchanges are
+// declared as topic exchanges instead of failing the binding, so that services
+// in the same process can be started in any order.
+func NewMemoryStreamConsumer(broker *MemoryBroker) StreamConsumer {
+	return &memoryStreamConsumer{broker: broker, done: make(chan struct{})}
+}
+
+func (c *memoryStreamConsumer) ConsumeChan(ctx context.Context, opts ConsumeOptions) (<-chan StreamMessage, error) {
+	strm, err := c.ensureStream(opts.Stream, opts.StreamOptions)
+	if err != nil {
+		return nil, err
+	}
+	offset := OffsetSpec.Next()
+	if opts.ConsumerOptions != nil {
+		offset = opts.ConsumerOptions.Offset
+	}
+	start, err := strm.startIdx(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = whileAll(ctx.Done(), c.done)
+	msgChan := make(chan StreamMessage, 100)
+	go func() {
+		defer close(msgChan)
+		for next := start; ; {
+			msgs, notify := strm.read(next)
+			for _, msg := range msgs {
+				if glog.V(10) {
+					glog.Infof("Read message from in-memory stream. stream=%q, offset=%d, data=%q", opts.Stream, next, string(msg.data))
+				}
+				select {
+				case msgChan <- StreamMessage{Message: &streamAmqp.Message{Data: [][]byte{msg.data}}}:
+					next++
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return msgChan, nil
+}
+
+func (c *memoryStreamConsumer) Consume(ctx context.Context, opts ConsumeOptions, handler Handler) error {
+	ctx, cancel := context.WithCancel(ctx)
+	msgs, err := c.ConsumeChan(ctx, opts)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	go func() {
+		defer cancel()
+
+		handleMsgRecv := func(msg StreamMessage) {
+			defer func() {
+				if rec := recover(); rec != nil {
+					glog.Fatalf("Panic in stream message handler. panicValue=%q message=%q stack=%q", rec, msg.Data, debug.Stack())
+				}
+			}()
+
+			handler.HandleMessage(msg)
+		}
+
+		for msg := range msgs {
+			handleMsgRecv(msg)
+		}
+	}()
+	return nil
+}
+
+func (c *memoryStreamConsumer) ensureStream(streamName string, opts *StreamOptions) (*memoryStream, error) {
+	c.broker.lock.Lock()
+	defer c.broker.lock.Unlock()
+	strm, exists := c.broker.streams[streamName]
+	if !exists {
+		if opts == nil {
+			return nil, fmt.Errorf("stream not found: %s", streamName)
+		}
+		strm = c.broker.declareStreamLocked(streamName)
+	}
+	if opts != nil {
+		for _, bind := range opts.Bindings {
+			if _, ok := c.broker.exchanges[bind.Exchange]; !ok {
+				glog.Infof("Declaring missing exchange for in-memory stream binding. exchange=%q stream=%q", bind.Exchange, streamName)
+				if err := c.broker.declareExchangeLocked(bind.Exchange, amqp.ExchangeTopic, false); err != nil {
+					return nil, fmt.Errorf("error declaring exchange %q: %w", bind.Exchange, err)
+				}
+			}
+			if err := c.broker.bindLocked(streamName, bind.Key, bind.Exchange, false); err != nil {
+				return nil, fmt.Errorf("error binding stream: queue bind to %q at %q: %w", bind.Exchange, bind.Key, err)
+			}
+		}
+	}
+	return strm, nil
+}
+
+func (c *memoryStreamConsumer) CheckConnection() error {
+	return nil
+}
+
+func (c *memoryStreamConsumer) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
+
+// startIdx returns the index of the first message to consume for the given
+// offset specification.
+func (s *memoryStream) startIdx(spec stream.OffsetSpecification) (int, error) {
+	kind, value, err := parseOffsetSpec(spec)
+	if err != nil {
+		return 0, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	length := len(s.messages)
+	switch kind {
+	case "first":
+		return 0, nil
+	case "last":
+		if length == 0 {
+			return 0, nil
+		}
+		return length - 1, nil
+	case "next":
+		return length, nil
+	case "offset":
+		if value < 0 {
+			return 0, nil
+		} else if value > int64(length) {
+			return length, nil
+		}
+		return int(value), nil
+	case "time-stamp":
+		ts := time.UnixMilli(value)
+		return sort.Search(length, func(i int) bool {
+			return !s.messages[i].timestamp.Before(ts)
+		}), nil
+	}
+	return 0, fmt.Errorf("unsupported offset specification: %s", spec)
+}
+
+// parseOffsetSpec reads the kind and value of an offset specification. The
+// stream client doesn't expose its fields, so this relies on its String format
+// instead, e.g. "first" or "offset, value: 10".
+func parseOffsetSpec(spec stream.OffsetSpecification) (kind string, value int64, err error) {
+	kind, rawValue, hasValue := strings.Cut(spec.String(), ", value: ")
+	if hasValue {
+		if value, err = strconv.ParseInt(rawValue, 10, 64); err != nil {
+			return "", 0, fmt.Errorf("invalid offset specification value: %w", err)
+		}
+	}
+	return kind, value, nil
+}
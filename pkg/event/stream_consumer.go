@@ -31,7 +31,15 @@ func init() {
 	}
 }
 
+// NewStreamConsumer creates a StreamConsumer connected to RabbitMQ, or to an
+// in-process MemoryBroker in case of a memory:// URI.
 func NewStreamConsumer(streamUriStr, amqpUriStr string) (StreamConsumer, error) {
+	for _, uri := range []string{streamUriStr, amqpUriStr} {
+		if broker, ok := memoryBrokerFromUri(uri); ok {
+			glog.Infof("Using in-memory broker. uri=%q", uri)
+			return NewMemoryStreamConsumer(broker), nil
+		}
+	}
 	streamUri, amqpUri, err := parseUris(streamUriStr, amqpUriStr)
 	if err != nil {
 		return nil, err
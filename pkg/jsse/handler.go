@@ -18,6 +18,12 @@ type Event struct {
 }
 
 func ServeEvents(ctx context.Context, opts Options, rw http.ResponseWriter, events <-chan Event) error {
+	if opts.WebSocket {
+		if opts.request == nil {
+			return HTTPError{http.StatusInternalServerError, errors.New("websocket options must be created from the request")}
+		}
+		return serveWebSocket(ctx, opts, rw, events)
+	}
 	switch opts.MimeType {
 	case MimeTypeJson:
 		ctx, cancel := context.WithTimeout(ctx, opts.PollMaxWaitTime)
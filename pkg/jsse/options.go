@@ -17,11 +17,22 @@ const (
 var SupportedMimeTypes = []string{MimeTypeEventStream, MimeTypeJson}
 
 type Options struct {
-	MimeType           string
+	MimeType string
+	// WebSocket is whether the request is a WebSocket handshake, in which case
+	// the events are sent over a WebSocket and MimeType is ignored.
+	WebSocket          bool
 	LastEventID        string
 	PollMaxWaitTime    time.Duration
 	ClientRetryBackoff time.Duration
 	PingPeriod         time.Duration
+	// AllowedOrigins are the origins other than the requested host which are
+	// allowed to open a WebSocket, like "https://livepeer.studio". Browsers
+	// send credentials in cross-site WebSocket handshakes, so any other origin
+	// is rejected. A "*" allows all of them.
+	AllowedOrigins []string
+
+	// request is kept for the WebSocket handshake.
+	request *http.Request
 }
 
 func InitOptions(r *http.Request) Options {
@@ -50,6 +61,8 @@ func InitOptions(r *http.Request) Options {
 		MimeType:        mimeType,
 		LastEventID:     lastEventID,
 		PollMaxWaitTime: maxWaitTime,
+		WebSocket:       isWebSocketUpgrade(r),
+		request:         r,
 	}
 }
 
@@ -63,6 +76,11 @@ func (o Options) WithPing(period time.Duration) Options {
 	return o
 }
 
+func (o Options) WithAllowedOrigins(origins []string) Options {
+	o.AllowedOrigins = origins
+	return o
+}
+
 func negotiateMimeType(acceptedHdrs []string, supported []string, defaultMime string) string {
 	for _, hdr := range acceptedHdrs {
 		for _, accept := range strings.Split(hdr, ",") {
@@ -0,0 +1,132 @@
+package jsse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/websocket"
+)
+
+// Events are sent over WebSockets as text messages with the JSON of the Event,
+// like in the long polling mode. Any messages from the clients are discarded.
+
+const (
+	webSocketWriteWait   = 10 * time.Second
+	maxWebSocketReadSize = 64 * 1024
+)
+
+func isWebSocketUpgrade(r *http.Request) bool {
+	return websocket.IsWebSocketUpgrade(r)
+}
+
+// isOriginAllowed protects against cross-site WebSocket hijacking, which the
+// same-origin policy of the browsers doesn't. Requests with no Origin are not
+// from browsers so they are allowed.
+func isOriginAllowed(origin, host string, allowed []string) bool {
+	if origin == "" {
+		return true
+	}
+	for _, allowedOrigin := range allowed {
+		if allowedOrigin == "*" || strings.EqualFold(allowedOrigin, origin) {
+			return true
+		}
+	}
+	originURL, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(originURL.Host, host)
+}
+
+// upgradeWebSocket validates the handshake and hijacks the connection. Errors
+// before hijacking the connection are returned as HTTPError.
+func upgradeWebSocket(rw http.ResponseWriter, r *http.Request, allowedOrigins []string) (*websocket.Conn, error) {
+	var httpErr error
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return isOriginAllowed(r.Header.Get("Origin"), r.Host, allowedOrigins)
+		},
+		Error: func(_ http.ResponseWriter, _ *http.Request, status int, reason error) {
+			httpErr = HTTPError{status, reason}
+		},
+	}
+	conn, err := upgrader.Upgrade(rw, r, nil)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	return conn, err
+}
+
+// serveWebSocket upgrades the connection and sends the events as text
+// messages until the channel is closed, the context is done or the client
+// closes the connection. Errors after the upgrade are only logged since they
+// can't be responded over HTTP anymore.
+func serveWebSocket(ctx context.Context, opts Options, rw http.ResponseWriter, events <-chan Event) error {
+	conn, err := upgradeWebSocket(rw, opts.request, opts.AllowedOrigins)
+	if _, isHTTPErr := err.(HTTPError); isHTTPErr {
+		return err
+	} else if err != nil {
+		glog.Warningf("Error upgrading to websocket. err=%q", err)
+		return nil
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		defer cancel()
+		discardMessages(conn)
+	}()
+
+	var pingC <-chan time.Time
+	if period := opts.PingPeriod; period > 0 {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		pingC = ticker.C
+	}
+	for {
+		select {
+		case <-pingC:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteWait))
+		case evt, ok := <-events:
+			if !ok {
+				writeClose(conn, websocket.CloseNormalClosure)
+				return nil
+			}
+			conn.SetWriteDeadline(time.Now().Add(webSocketWriteWait))
+			err = conn.WriteJSON(&evt)
+		case <-ctx.Done():
+			writeClose(conn, websocket.CloseGoingAway)
+			return nil
+		}
+		if err != nil {
+			if !errors.Is(err, websocket.ErrCloseSent) && !errors.Is(err, net.ErrClosed) {
+				glog.Warningf("Error writing to websocket, closing connection. err=%q", err)
+			}
+			return nil
+		}
+	}
+}
+
+func writeClose(conn *websocket.Conn, code int) {
+	msg := websocket.FormatCloseMessage(code, "")
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(webSocketWriteWait))
+}
+
+// discardMessages reads from the connection so the control frames from the
+// client are handled, returning when the connection is closed.
+func discardMessages(conn *websocket.Conn) {
+	conn.SetReadLimit(maxWebSocketReadSize)
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) &&
+				!errors.Is(err, net.ErrClosed) {
+				glog.V(4).Infof("Error reading from websocket. err=%q", err)
+			}
+			return
+		}
+	}
+}
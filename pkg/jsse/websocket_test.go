@@ -0,0 +1,85 @@
+package jsse
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeEventsWebSocket(t *testing.T) {
+	require := require.New(t)
+	events := make(chan Event, 2)
+	events <- Event{ID: "1", Event: "stream_state", Data: json.RawMessage(`{"a":1}`)}
+	events <- Event{ID: "2", Event: "stream_state", Data: json.RawMessage(`{"a":2}`)}
+	close(events)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ServeEvents(r.Context(), InitOptions(r).WithPing(time.Hour), rw, events)
+	}))
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/events"
+	conn, res, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(err)
+	defer conn.Close()
+	require.Equal(http.StatusSwitchingProtocols, res.StatusCode)
+
+	for _, id := range []string{"1", "2"} {
+		msgType, payload, err := conn.ReadMessage()
+		require.NoError(err)
+		require.Equal(websocket.TextMessage, msgType)
+		var evt Event
+		require.NoError(json.Unmarshal(payload, &evt))
+		require.Equal(id, evt.ID)
+		require.Equal("stream_state", evt.Event)
+	}
+	_, _, err = conn.ReadMessage()
+	require.True(websocket.IsCloseError(err, websocket.CloseNormalClosure), "unexpected error: %v", err)
+}
+
+func TestServeEventsWebSocketBadHandshake(t *testing.T) {
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+
+	opts := InitOptions(req)
+	require.True(t, opts.WebSocket)
+	err := ServeEvents(req.Context(), opts, httptest.NewRecorder(), make(chan Event))
+	require.Equal(t, http.StatusBadRequest, err.(HTTPError).StatusCode)
+}
+
+func TestServeEventsWebSocketOrigin(t *testing.T) {
+	require := require.New(t)
+	newRequest := func(origin string) *http.Request {
+		req := httptest.NewRequest("GET", "http://livepeer.studio/events", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef")))
+		req.Header.Set("Origin", origin)
+		return req
+	}
+
+	req := newRequest("https://evil.example")
+	err := ServeEvents(req.Context(), InitOptions(req), httptest.NewRecorder(), make(chan Event))
+	require.Equal(http.StatusForbidden, err.(HTTPError).StatusCode)
+
+	// passes the origin check, but the recorder can't be hijacked
+	opts := InitOptions(req).WithAllowedOrigins([]string{"https://evil.example"})
+	err = ServeEvents(req.Context(), opts, httptest.NewRecorder(), make(chan Event))
+	require.Equal(http.StatusInternalServerError, err.(HTTPError).StatusCode)
+
+	require.True(isOriginAllowed("", "livepeer.studio", nil))
+	require.True(isOriginAllowed("https://LIVEPEER.studio", "livepeer.studio", nil))
+	require.False(isOriginAllowed("https://livepeer.studio.evil.example", "livepeer.studio", nil))
+	require.False(isOriginAllowed("null", "livepeer.studio", nil))
+	require.True(isOriginAllowed("null", "livepeer.studio", []string{"*"}))
+}
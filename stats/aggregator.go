@@ -1,10 +1,33 @@
 package stats
 
-import "time"
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
 
+// maxExactPercentileMeasures is how many measures an exact aggregator sorts to
+// compute the exact percentiles. Larger windows estimate them with a t-digest
+// instead, which doesn't need to copy and sort all of the measures.
+const maxExactPercentileMeasures = 1000
+
+// Aggregator computes stats over a sliding time window of measures. The zero
+// value keeps every measure for exact stats, while the ones created with
+// NewBucketedAggregator use bounded memory instead.
 type Aggregator struct {
 	measures []measure
 	sum      float64
+	sumSq    float64
+	// digest estimates the percentiles of more than maxExactPercentileMeasures.
+	// It is updated on Add, but t-digests can't remove values so it is dropped
+	// when measures are clipped and rebuilt on the next Percentile.
+	digest *TDigest
+
+	// buckets is set for bucketed aggregators, in which case measures is unused.
+	buckets *bucketRing
 }
 
 type measure struct {
@@ -12,13 +35,33 @@ type measure struct {
 	value     float64
 }
 
+// NewBucketedAggregator creates an aggregator that groups measures in buckets
+// of the given time resolution, supporting windows of up to the given size.
+// The stats are then approximated at bucket granularity, except for the count,
+// sum and average which are exact for timestamps aligned to the resolution.
+// Returns an exact aggregator if resolution is not positive.
+func NewBucketedAggregator(window, resolution time.Duration) *Aggregator {
+	if resolution <= 0 {
+		return &Aggregator{}
+	}
+	return &Aggregator{buckets: newBucketRing(window, resolution)}
+}
+
 func (a *Aggregator) Add(ts time.Time, value float64) *Aggregator {
+	if a.buckets != nil {
+		a.buckets.add(ts, value)
+		return a
+	}
 	a.sum += value
+	a.sumSq += value * value
 	insertIdx := len(a.measures)
 	for insertIdx > 0 && ts.Before(a.measures[insertIdx-1].timestamp) {
 		insertIdx--
 	}
 	a.measures = insertMeasure(a.measures, insertIdx, measure{ts, value})
+	if a.digest != nil {
+		a.digest.Add(value)
+	}
 	return a
 }
 
@@ -30,20 +73,203 @@ func insertMeasure(slc []measure, idx int, val measure) []measure {
 }
 
 func (a *Aggregator) Clip(window time.Duration) *Aggregator {
+	if a.buckets != nil {
+		a.buckets.clip(a.buckets.latest.Add(-window))
+		return a
+	}
 	if len(a.measures) == 0 {
 		return a
 	}
-	threshold := a.measures[len(a.measures)-1].timestamp.Add(-window)
+	return a.clipBefore(a.measures[len(a.measures)-1].timestamp.Add(-window))
+}
+
+// ClipAt drops the measures out of the window that ends at the given time,
+// instead of at the latest measure like Clip. Used to expire the measures of
+// series that are not updated anymore.
+func (a *Aggregator) ClipAt(end time.Time, window time.Duration) *Aggregator {
+	if a.buckets != nil {
+		a.buckets.clip(end.Add(-window))
+		return a
+	}
+	return a.clipBefore(end.Add(-window))
+}
+
+func (a *Aggregator) clipBefore(threshold time.Time) *Aggregator {
 	for len(a.measures) > 0 && !threshold.Before(a.measures[0].timestamp) {
 		a.sum -= a.measures[0].value
+		a.sumSq -= a.measures[0].value * a.measures[0].value
 		a.measures = a.measures[1:]
+		a.digest = nil
 	}
 	return a
 }
 
+// Compute returns the value of the given stat over the measures, or 0 if the
+// stat is unknown.
+func (a *Aggregator) Compute(stat Stat) float64 {
+	switch stat {
+	case StatCount:
+		return float64(a.Count())
+	case StatSum:
+		return a.Sum()
+	case StatAverage:
+		return a.Average()
+	case StatMin:
+		return a.Min()
+	case StatMax:
+		return a.Max()
+	case StatStdDev:
+		return a.StdDev()
+	}
+	if p, ok := stat.percentile(); ok {
+		return a.Percentile(p)
+	}
+	return 0
+}
+
+func (a Aggregator) Count() int {
+	if a.buckets != nil {
+		return a.buckets.count
+	}
+	return len(a.measures)
+}
+
+func (a Aggregator) Sum() float64 {
+	if a.buckets != nil {
+		return a.buckets.sum
+	}
+	return a.sum
+}
+
 func (a Aggregator) Average() float64 {
+	if count := a.Count(); count > 0 {
+		return a.Sum() / float64(count)
+	}
+	return 0
+}
+
+// StdDev returns the population standard deviation of the measures.
+func (a Aggregator) StdDev() float64 {
+	count, sumSq := a.Count(), a.sumSq
+	if a.buckets != nil {
+		sumSq = a.buckets.sumSq
+	}
+	if count == 0 {
+		return 0
+	}
+	n := float64(count)
+	mean := a.Sum() / n
+	// clamp rounding errors from the running sums
+	return math.Sqrt(math.Max(0, sumSq/n-mean*mean))
+}
+
+func (a Aggregator) Min() float64 {
+	if a.buckets != nil {
+		return a.buckets.min()
+	}
+	if len(a.measures) == 0 {
+		return 0
+	}
+	min := a.measures[0].value
+	for _, m := range a.measures[1:] {
+		if m.value < min {
+			min = m.value
+		}
+	}
+	return min
+}
+
+func (a Aggregator) Max() float64 {
+	if a.buckets != nil {
+		return a.buckets.max()
+	}
+	if len(a.measures) == 0 {
+		return 0
+	}
+	max := a.measures[0].value
+	for _, m := range a.measures[1:] {
+		if m.value > max {
+			max = m.value
+		}
+	}
+	return max
+}
+
+// Percentile returns the nearest-rank p-th percentile of the measures, with p
+// in the [0, 100] range. Bucketed aggregators and exact ones with more than
+// maxExactPercentileMeasures estimate it with t-digests. It may build the
+// t-digest of the aggregator, so it is not safe for concurrent use either.
+func (a *Aggregator) Percentile(p float64) float64 {
+	if a.buckets != nil {
+		return a.buckets.percentile(p)
+	}
 	if len(a.measures) == 0 {
 		return 0
+	} else if len(a.measures) > maxExactPercentileMeasures {
+		if a.digest == nil {
+			a.digest = NewTDigest(DefaultCompression)
+			for _, m := range a.measures {
+				a.digest.Add(m.value)
+			}
+		}
+		return a.digest.Quantile(p / 100)
+	}
+	values := make([]float64, len(a.measures))
+	for i, m := range a.measures {
+		values[i] = m.value
+	}
+	sort.Float64s(values)
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	} else if rank > len(values) {
+		rank = len(values)
+	}
+	return values[rank-1]
+}
+
+// aggregatorGob is the serialized form of an Aggregator, used to persist it
+// with encoding/gob since the measures are unexported.
+type aggregatorGob struct {
+	Timestamps []time.Time
+	Values     []float64
+	Buckets    *bucketRingGob
+}
+
+func (a *Aggregator) GobEncode() ([]byte, error) {
+	enc := aggregatorGob{
+		Timestamps: make([]time.Time, len(a.measures)),
+		Values:     make([]float64, len(a.measures)),
+	}
+	for i, m := range a.measures {
+		enc.Timestamps[i], enc.Values[i] = m.timestamp, m.value
+	}
+	if a.buckets != nil {
+		enc.Buckets = a.buckets.toGob()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(enc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (a *Aggregator) GobDecode(raw []byte) error {
+	var dec aggregatorGob
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&dec); err != nil {
+		return err
+	} else if len(dec.Timestamps) != len(dec.Values) {
+		return fmt.Errorf("inconsistent aggregator measures: %d timestamps, %d values", len(dec.Timestamps), len(dec.Values))
+	}
+	if dec.Buckets != nil {
+		*a = Aggregator{buckets: dec.Buckets.toRing()}
+		return nil
+	}
+	*a = Aggregator{measures: make([]measure, len(dec.Values))}
+	for i := range dec.Values {
+		a.measures[i] = measure{dec.Timestamps[i], dec.Values[i]}
+		a.sum += dec.Values[i]
+		a.sumSq += dec.Values[i] * dec.Values[i]
 	}
-	return a.sum / float64(len(a.measures))
+	return nil
 }
@@ -0,0 +1,212 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregatorCompute(t *testing.T) {
+	require := require.New(t)
+	aggr := &Aggregator{}
+	for i, value := range []float64{4, 2, 8, 6} {
+		aggr.Add(testTime(i), value)
+	}
+	require.Equal(4.0, aggr.Compute(StatCount))
+	require.Equal(20.0, aggr.Compute(StatSum))
+	require.Equal(5.0, aggr.Compute(StatAverage))
+	require.Equal(2.0, aggr.Compute(StatMin))
+	require.Equal(8.0, aggr.Compute(StatMax))
+	require.InDelta(math.Sqrt(5), aggr.Compute(StatStdDev), 1e-9)
+	require.Equal(4.0, aggr.Compute(PercentileStat(50)))
+	require.Equal(8.0, aggr.Compute(PercentileStat(95)))
+
+	stat, err := ParseStat("p99.9")
+	require.NoError(err)
+	require.Equal(PercentileStat(99.9), stat)
+	_, err = ParseStat("p101")
+	require.Error(err)
+	_, err = ParseStat("median")
+	require.Error(err)
+}
+
+func TestAggregatorPercentileEstimate(t *testing.T) {
+	require := require.New(t)
+	aggr := &Aggregator{}
+	for i := 0; i < 10*maxExactPercentileMeasures; i++ {
+		aggr.Add(testTime(i), float64(i%100))
+	}
+	require.InDelta(50, aggr.Percentile(50), 2)
+	require.InDelta(95, aggr.Percentile(95), 2)
+	require.Equal(99.0, aggr.Percentile(100))
+
+	// the digest is kept up to date on Add instead of rebuilt on every call
+	digest := aggr.digest
+	require.NotNil(digest)
+	aggr.Add(testTime(10*maxExactPercentileMeasures), 1000)
+	require.Equal(1000.0, aggr.Percentile(100))
+	require.Same(digest, aggr.digest)
+	require.Equal(float64(aggr.Count()), digest.Count())
+
+	// clipped measures can't be removed from the digest, so it is rebuilt
+	aggr.Clip(time.Duration(5*maxExactPercentileMeasures) * time.Second)
+	require.Nil(aggr.digest)
+	require.Equal(1000.0, aggr.Percentile(100))
+	require.Equal(float64(aggr.Count()), aggr.digest.Count())
+}
+
+func testTime(sec int) time.Time {
+	return time.Unix(1700000000+int64(sec), 0)
+}
+
+func TestBucketedAggregatorEquivalence(t *testing.T) {
+	windows := []time.Duration{time.Minute, 10 * time.Minute}
+	for _, resolution := range []time.Duration{time.Second, 5 * time.Second} {
+		t.Run(resolution.String(), func(t *testing.T) {
+			require := require.New(t)
+			rng := rand.New(rand.NewSource(int64(resolution)))
+			exact, bucketed := WindowAggregators{}, NewWindowAggregators(windows, resolution)
+
+			ts := testTime(0)
+			for i := 0; i < 5000; i++ {
+				// mostly steady measures with some gaps and out of order ones
+				step := time.Duration(rng.Intn(3)) * resolution
+				if rng.Intn(100) == 0 {
+					step = time.Duration(rng.Intn(1000)) * resolution
+				}
+				ts = ts.Add(step)
+				measureTs := ts
+				if rng.Intn(10) == 0 {
+					measureTs = ts.Add(-time.Duration(rng.Intn(20)) * resolution)
+				}
+				value := rng.Float64() * 100
+
+				expected := exact.Averages(windows, measureTs, &value)
+				actual := bucketed.Averages(windows, measureTs, &value)
+				require.Len(actual, len(expected))
+				for window, avg := range expected {
+					require.InDelta(avg, actual[window], 1e-6, "measure %d window %s", i, window.Duration)
+					exactAggr, bucketedAggr := exact[window], bucketed[window]
+					require.Equal(exactAggr.Count(), bucketedAggr.Count())
+					require.Equal(exactAggr.Min(), bucketedAggr.Min())
+					require.Equal(exactAggr.Max(), bucketedAggr.Max())
+					// running sums of squares lose precision on both implementations
+					require.InDelta(exactAggr.StdDev(), bucketedAggr.StdDev(), 1e-3)
+				}
+			}
+		})
+	}
+}
+
+func TestBucketedAggregatorUnalignedTimestamps(t *testing.T) {
+	windows := []time.Duration{time.Minute, 10 * time.Minute}
+	for _, resolution := range []time.Duration{time.Second, 5 * time.Second} {
+		t.Run(resolution.String(), func(t *testing.T) {
+			require := require.New(t)
+			rng := rand.New(rand.NewSource(int64(resolution)))
+			exact, bucketed := WindowAggregators{}, NewWindowAggregators(windows, resolution)
+
+			// the bucketed aggregator keeps a measure only while the start of
+			// its bucket is after the window start, so it must have exactly
+			// the measures of the exact one that are not in the first bucket.
+			var all []measure
+			var latest time.Time
+			expectedIn := func(window time.Duration, bucketStart bool) (count int, sum, min, max float64) {
+				threshold := latest.Add(-window)
+				min, max = math.Inf(1), math.Inf(-1)
+				for _, m := range all {
+					ts := m.timestamp
+					if bucketStart {
+						ts = time.Unix(0, ts.UnixNano()/int64(resolution)*int64(resolution))
+					}
+					if !ts.After(threshold) {
+						continue
+					}
+					count++
+					sum += m.value
+					min, max = math.Min(min, m.value), math.Max(max, m.value)
+				}
+				return
+			}
+
+			ts := testTime(0)
+			for i := 0; i < 2000; i++ {
+				ts = ts.Add(time.Duration(rng.Int63n(int64(3 * resolution))))
+				measureTs := ts
+				window := windows[rng.Intn(len(windows))]
+				switch rng.Intn(20) {
+				case 0:
+					// right on the window start, out of both windows
+					measureTs = latest.Add(-window)
+				case 1:
+					// right after the window start, in the exact window only
+					// unless the window start is at a bucket boundary
+					measureTs = latest.Add(-window + 1)
+				case 2:
+					// right on a bucket boundary
+					measureTs = ts.Truncate(resolution)
+				case 3:
+					measureTs = ts.Add(-time.Duration(rng.Int63n(int64(20 * resolution))))
+				}
+				if latest.IsZero() {
+					measureTs = ts
+				}
+				value := rng.Float64() * 100
+				all = append(all, measure{measureTs, value})
+				if measureTs.After(latest) {
+					latest = measureTs
+				}
+
+				exact.Averages(windows, measureTs, &value)
+				bucketed.Averages(windows, measureTs, &value)
+				for _, window := range windows {
+					exactAggr, bucketedAggr := exact[Window{window}], bucketed[Window{window}]
+					for _, check := range []struct {
+						aggr        *Aggregator
+						bucketStart bool
+					}{{exactAggr, false}, {bucketedAggr, true}} {
+						count, sum, min, max := expectedIn(window, check.bucketStart)
+						require.Equal(count, check.aggr.Count(), "measure %d window %s", i, window)
+						require.InDelta(sum, check.aggr.Sum(), 1e-6, "measure %d window %s", i, window)
+						if count > 0 {
+							require.Equal(min, check.aggr.Min())
+							require.Equal(max, check.aggr.Max())
+						}
+					}
+					// the bucketed window is never longer than the exact one
+					// nor shorter by more than a bucket
+					require.LessOrEqual(bucketedAggr.Count(), exactAggr.Count())
+					shorterCount, _, _, _ := expectedIn(window-resolution, false)
+					require.GreaterOrEqual(bucketedAggr.Count(), shorterCount)
+				}
+			}
+		})
+	}
+}
+
+func TestBucketedAggregatorBoundsMemory(t *testing.T) {
+	require := require.New(t)
+	aggr := NewBucketedAggregator(time.Minute, time.Second)
+	for i := 0; i < 100000; i++ {
+		aggr.Add(testTime(0).Add(time.Duration(i)*10*time.Millisecond), float64(i%100)).Clip(time.Minute)
+	}
+	require.Len(aggr.buckets.slots, 61)
+	require.Equal(6000, aggr.Count())
+	require.InDelta(49.5, aggr.Average(), 0.1)
+	require.InDelta(95, aggr.Percentile(95), 2)
+
+	var buf bytes.Buffer
+	require.NoError(gob.NewEncoder(&buf).Encode(aggr))
+	decoded := &Aggregator{}
+	require.NoError(gob.NewDecoder(&buf).Decode(decoded))
+	require.Equal(aggr.Count(), decoded.Count())
+	require.InDelta(aggr.Average(), decoded.Average(), 1e-9)
+	require.Equal(aggr.Max(), decoded.Max())
+	decoded.Add(testTime(2000), 1).Clip(time.Minute)
+	require.Equal(1, decoded.Count())
+}
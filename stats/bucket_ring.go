@@ -0,0 +1,209 @@
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+// BucketCompression is the compression of the t-digest kept on each bucket
+// for estimating percentiles. Lower than the default to bound memory usage.
+const BucketCompression = 20
+
+// bucketRing aggregates measures into a ring of fixed time buckets, so memory
+// is bounded by the window size and resolution instead of the measure count.
+// Adding and clipping are amortized O(1).
+//
+// A measure at time ts goes to the bucket of index floor(ts / resolution). The
+// window is clipped at bucket granularity, dropping the whole bucket that
+// contains the window start. This matches the exact aggregator for measures
+// with timestamps aligned to the resolution and is an approximation otherwise.
+type bucketRing struct {
+	resolution int64
+	slots      []bucket
+	// headIdx and tailIdx are the indexes of the newest and oldest buckets
+	// that may be still in the window.
+	headIdx, tailIdx int64
+	latest           time.Time
+
+	count      int
+	sum, sumSq float64
+}
+
+type bucket struct {
+	idx        int64
+	count      int
+	sum, sumSq float64
+	min, max   float64
+	digest     *TDigest
+}
+
+func newBucketRing(window, resolution time.Duration) *bucketRing {
+	size := int(math.Ceil(float64(window)/float64(resolution))) + 1
+	return &bucketRing{
+		resolution: int64(resolution),
+		slots:      make([]bucket, size),
+	}
+}
+
+func (r *bucketRing) slot(idx int64) *bucket {
+	size := int64(len(r.slots))
+	return &r.slots[((idx%size)+size)%size]
+}
+
+func (r *bucketRing) bucketIdx(ts time.Time) int64 {
+	nanos := ts.UnixNano()
+	idx := nanos / r.resolution
+	if nanos%r.resolution < 0 {
+		idx--
+	}
+	return idx
+}
+
+func (r *bucketRing) add(ts time.Time, value float64) {
+	idx := r.bucketIdx(ts)
+	if r.count == 0 {
+		r.headIdx, r.tailIdx = idx, idx
+	} else if idx > r.headIdx {
+		// evict the buckets that the head is moving over from a past lap
+		for i := r.headIdx + 1; i <= idx && i <= r.headIdx+int64(len(r.slots)); i++ {
+			r.evict(r.slot(i))
+		}
+		r.headIdx = idx
+	}
+	if oldest := r.headIdx - int64(len(r.slots)) + 1; idx < oldest {
+		// older than any window the ring can hold
+		return
+	} else if r.tailIdx < oldest {
+		r.tailIdx = oldest
+	}
+	if idx < r.tailIdx {
+		r.tailIdx = idx
+	}
+
+	b := r.slot(idx)
+	if b.count > 0 && b.idx != idx {
+		r.evict(b)
+	}
+	if b.count == 0 {
+		*b = bucket{idx: idx, min: value, max: value, digest: NewTDigest(BucketCompression)}
+	}
+	b.count++
+	b.sum += value
+	b.sumSq += value * value
+	b.min, b.max = math.Min(b.min, value), math.Max(b.max, value)
+	b.digest.Add(value)
+
+	r.count++
+	r.sum += value
+	r.sumSq += value * value
+	if ts.After(r.latest) {
+		r.latest = ts
+	}
+}
+
+func (r *bucketRing) evict(b *bucket) {
+	if b.count == 0 {
+		return
+	}
+	r.count -= b.count
+	r.sum -= b.sum
+	r.sumSq -= b.sumSq
+	*b = bucket{}
+	if r.count == 0 {
+		// avoid carrying rounding errors from the running sums
+		r.sum, r.sumSq = 0, 0
+	}
+}
+
+// clip drops the buckets whose start time is not after the window start.
+func (r *bucketRing) clip(windowStart time.Time) {
+	if r.count == 0 {
+		return
+	}
+	threshold := windowStart.UnixNano()
+	for ; r.tailIdx <= r.headIdx && r.tailIdx*r.resolution <= threshold; r.tailIdx++ {
+		if b := r.slot(r.tailIdx); b.idx == r.tailIdx {
+			r.evict(b)
+		}
+	}
+}
+
+func (r *bucketRing) forEach(fn func(b *bucket)) {
+	for i := range r.slots {
+		if b := &r.slots[i]; b.count > 0 {
+			fn(b)
+		}
+	}
+}
+
+func (r *bucketRing) min() float64 {
+	min := math.Inf(1)
+	r.forEach(func(b *bucket) { min = math.Min(min, b.min) })
+	if r.count == 0 {
+		return 0
+	}
+	return min
+}
+
+func (r *bucketRing) max() float64 {
+	max := math.Inf(-1)
+	r.forEach(func(b *bucket) { max = math.Max(max, b.max) })
+	if r.count == 0 {
+		return 0
+	}
+	return max
+}
+
+func (r *bucketRing) percentile(p float64) float64 {
+	digest := NewTDigest(DefaultCompression)
+	r.forEach(func(b *bucket) { digest.Merge(b.digest) })
+	return digest.Quantile(p / 100)
+}
+
+// bucketRingGob is the serialized form of a bucketRing.
+type bucketRingGob struct {
+	Resolution       int64
+	Size             int
+	HeadIdx, TailIdx int64
+	Latest           time.Time
+	Buckets          []bucketGob
+}
+
+type bucketGob struct {
+	Idx        int64
+	Count      int
+	Sum, SumSq float64
+	Min, Max   float64
+	Digest     *TDigest
+}
+
+func (r *bucketRing) toGob() *bucketRingGob {
+	enc := &bucketRingGob{
+		Resolution: r.resolution,
+		Size:       len(r.slots),
+		HeadIdx:    r.headIdx,
+		TailIdx:    r.tailIdx,
+		Latest:     r.latest,
+	}
+	r.forEach(func(b *bucket) {
+		enc.Buckets = append(enc.Buckets, bucketGob{b.idx, b.count, b.sum, b.sumSq, b.min, b.max, b.digest})
+	})
+	return enc
+}
+
+func (dec *bucketRingGob) toRing() *bucketRing {
+	r := &bucketRing{
+		resolution: dec.Resolution,
+		slots:      make([]bucket, dec.Size),
+		headIdx:    dec.HeadIdx,
+		tailIdx:    dec.TailIdx,
+		latest:     dec.Latest,
+	}
+	for _, b := range dec.Buckets {
+		*r.slot(b.Idx) = bucket{b.Idx, b.Count, b.Sum, b.SumSq, b.Min, b.Max, b.Digest}
+		r.count += b.Count
+		r.sum += b.Sum
+		r.sumSq += b.SumSq
+	}
+	return r
+}
@@ -0,0 +1,49 @@
+package stats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Stat is a statistic that can be computed over the measures of a window.
+type Stat string
+
+const (
+	StatCount   Stat = "count"
+	StatSum     Stat = "sum"
+	StatAverage Stat = "avg"
+	StatMin     Stat = "min"
+	StatMax     Stat = "max"
+	StatStdDev  Stat = "stddev"
+)
+
+// PercentileStat returns the stat for the p-th percentile of the measures,
+// named "p<p>" like "p95" or "p99.9".
+func PercentileStat(p float64) Stat {
+	return Stat("p" + strconv.FormatFloat(p, 'f', -1, 64))
+}
+
+// ParseStat parses and validates a stat name.
+func ParseStat(str string) (Stat, error) {
+	stat := Stat(str)
+	switch stat {
+	case StatCount, StatSum, StatAverage, StatMin, StatMax, StatStdDev:
+		return stat, nil
+	}
+	if _, ok := stat.percentile(); ok {
+		return stat, nil
+	}
+	return "", fmt.Errorf("unknown stat %q", str)
+}
+
+func (s Stat) percentile() (float64, bool) {
+	if !strings.HasPrefix(string(s), "p") {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(string(s[1:]), 64)
+	if err != nil || p < 0 || p > 100 {
+		return 0, false
+	}
+	return p, true
+}
@@ -0,0 +1,190 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const DefaultCompression = 100
+
+// TDigest is a streaming sketch for estimating quantiles of a distribution
+// with bounded memory, more accurate on the tails. It is the merging variant
+// from Dunning's "Computing Extremely Accurate Quantiles Using t-Digests",
+// keeping at most around compression centroids. It is not safe for concurrent
+// use, even for reading: Quantile and GobEncode merge the buffered values into
+// the centroids first, so they mutate the digest like Add.
+type TDigest struct {
+	compression float64
+	centroids   []centroid
+	unmerged    []centroid
+	count       float64
+	min, max    float64
+}
+
+type centroid struct {
+	mean, weight float64
+}
+
+func NewTDigest(compression float64) *TDigest {
+	if compression <= 0 {
+		compression = DefaultCompression
+	}
+	return &TDigest{compression: compression, min: math.Inf(1), max: math.Inf(-1)}
+}
+
+func (d *TDigest) Add(value float64) {
+	d.AddWeighted(value, 1)
+}
+
+func (d *TDigest) AddWeighted(value, weight float64) {
+	if weight <= 0 || math.IsNaN(value) {
+		return
+	}
+	d.unmerged = append(d.unmerged, centroid{value, weight})
+	d.count += weight
+	d.min, d.max = math.Min(d.min, value), math.Max(d.max, value)
+	if len(d.unmerged) >= d.bufferSize() {
+		d.compress()
+	}
+}
+
+// Merge adds all the values from the other digest into this one.
+func (d *TDigest) Merge(other *TDigest) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	d.unmerged = append(d.unmerged, other.centroids...)
+	d.unmerged = append(d.unmerged, other.unmerged...)
+	d.count += other.count
+	d.min, d.max = math.Min(d.min, other.min), math.Max(d.max, other.max)
+	d.compress()
+}
+
+func (d *TDigest) Count() float64 {
+	return d.count
+}
+
+// Quantile returns the estimated value at quantile q, with q in the [0, 1]
+// range. Returns 0 if the digest is empty. Merges the buffered values, so it
+// must not be called concurrently with any other method.
+func (d *TDigest) Quantile(q float64) float64 {
+	if d.count == 0 {
+		return 0
+	}
+	d.compress()
+	if q <= 0 {
+		return d.min
+	} else if q >= 1 {
+		return d.max
+	} else if len(d.centroids) == 1 {
+		return d.centroids[0].mean
+	}
+
+	target := q * d.count
+	first, last := d.centroids[0], d.centroids[len(d.centroids)-1]
+	if target < first.weight/2 {
+		return interpolate(d.min, first.mean, target/(first.weight/2))
+	} else if target >= d.count-last.weight/2 {
+		return interpolate(last.mean, d.max, (target-(d.count-last.weight/2))/(last.weight/2))
+	}
+
+	// find the pair of centroids whose centers surround the target weight
+	center := first.weight / 2
+	for i := 1; i < len(d.centroids); i++ {
+		prev, curr := d.centroids[i-1], d.centroids[i]
+		nextCenter := center + (prev.weight+curr.weight)/2
+		if target < nextCenter {
+			return interpolate(prev.mean, curr.mean, (target-center)/(nextCenter-center))
+		}
+		center = nextCenter
+	}
+	return last.mean
+}
+
+func interpolate(from, to, ratio float64) float64 {
+	return from + (to-from)*ratio
+}
+
+func (d *TDigest) bufferSize() int {
+	return int(math.Ceil(d.compression)) * 5
+}
+
+// compress merges the unmerged values into the centroids, merging adjacent
+// centroids for as long as they span at most 1 unit of the k1 scale function.
+func (d *TDigest) compress() {
+	if len(d.unmerged) == 0 {
+		return
+	}
+	all := append(d.centroids, d.unmerged...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := make([]centroid, 0, int(d.compression))
+	curr, weightSoFar := all[0], 0.0
+	for _, next := range all[1:] {
+		proposed := curr.weight + next.weight
+		kLeft := d.scale(weightSoFar / d.count)
+		kRight := d.scale((weightSoFar + proposed) / d.count)
+		if kRight-kLeft <= 1 {
+			curr.mean += (next.mean - curr.mean) * next.weight / proposed
+			curr.weight = proposed
+			continue
+		}
+		merged = append(merged, curr)
+		weightSoFar += curr.weight
+		curr = next
+	}
+	d.centroids = append(merged, curr)
+	d.unmerged = nil
+}
+
+func (d *TDigest) scale(q float64) float64 {
+	return d.compression / (2 * math.Pi) * math.Asin(2*math.Min(q, 1)-1)
+}
+
+// tdigestGob is the serialized form of a TDigest.
+type tdigestGob struct {
+	Compression float64
+	Means       []float64
+	Weights     []float64
+	Min, Max    float64
+}
+
+func (d *TDigest) GobEncode() ([]byte, error) {
+	d.compress()
+	enc := tdigestGob{
+		Compression: d.compression,
+		Means:       make([]float64, len(d.centroids)),
+		Weights:     make([]float64, len(d.centroids)),
+		Min:         d.min,
+		Max:         d.max,
+	}
+	for i, c := range d.centroids {
+		enc.Means[i], enc.Weights[i] = c.mean, c.weight
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(enc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *TDigest) GobDecode(raw []byte) error {
+	var dec tdigestGob
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&dec); err != nil {
+		return err
+	} else if len(dec.Means) != len(dec.Weights) {
+		return fmt.Errorf("inconsistent t-digest centroids: %d means, %d weights", len(dec.Means), len(dec.Weights))
+	}
+	*d = *NewTDigest(dec.Compression)
+	if len(dec.Means) > 0 {
+		d.min, d.max = dec.Min, dec.Max
+	}
+	for i := range dec.Means {
+		d.centroids = append(d.centroids, centroid{dec.Means[i], dec.Weights[i]})
+		d.count += dec.Weights[i]
+	}
+	return nil
+}
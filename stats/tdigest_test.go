@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTDigestQuantiles(t *testing.T) {
+	require := require.New(t)
+	rng := rand.New(rand.NewSource(42))
+	digest, values := NewTDigest(DefaultCompression), make([]float64, 100000)
+	for i := range values {
+		values[i] = rng.NormFloat64()*10 + 50
+		digest.Add(values[i])
+	}
+	sort.Float64s(values)
+
+	require.Equal(float64(len(values)), digest.Count())
+	require.LessOrEqual(len(digest.centroids), 2*DefaultCompression)
+	require.Equal(values[0], digest.Quantile(0))
+	require.Equal(values[len(values)-1], digest.Quantile(1))
+	for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.95, 0.99} {
+		exact := values[int(q*float64(len(values)))]
+		require.InDelta(exact, digest.Quantile(q), 0.5, "quantile %v", q)
+	}
+}
+
+func TestTDigestMergeAndGob(t *testing.T) {
+	require := require.New(t)
+	a, b := NewTDigest(50), NewTDigest(50)
+	for i := 0; i < 1000; i++ {
+		a.Add(float64(i))
+		b.Add(float64(1000 + i))
+	}
+	a.Merge(b)
+	require.Equal(2000.0, a.Count())
+	require.InDelta(1000, a.Quantile(0.5), 10)
+
+	var buf bytes.Buffer
+	require.NoError(gob.NewEncoder(&buf).Encode(a))
+	decoded := &TDigest{}
+	require.NoError(gob.NewDecoder(&buf).Decode(decoded))
+	require.Equal(a.Count(), decoded.Count())
+	require.Equal(a.Quantile(0.9), decoded.Quantile(0.9))
+
+	require.Equal(0.0, NewTDigest(0).Quantile(0.5))
+}
@@ -6,19 +6,78 @@ type WindowAggregators map[Window]*Aggregator
 
 type ByWindow = map[Window]float64
 
+// Summary has statistics of the measures in a time window.
+type Summary struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Avg float64 `json:"avg"`
+	P95 float64 `json:"p95"`
+}
+
+type SummaryByWindow = map[Window]Summary
+
+// Values are the values of a set of stats.
+type Values = map[Stat]float64
+
+type ValuesByWindow = map[Window]Values
+
+// NewWindowAggregators creates the aggregators for the given windows, bucketed
+// with the given resolution. Aggregators for windows not created here are
+// exact, keeping every measure.
+func NewWindowAggregators(windows []time.Duration, resolution time.Duration) WindowAggregators {
+	aggrs := make(WindowAggregators, len(windows))
+	for _, window := range windows {
+		aggrs[Window{window}] = NewBucketedAggregator(window, resolution)
+	}
+	return aggrs
+}
+
 func (a WindowAggregators) Averages(windows []time.Duration, ts time.Time, measure *float64) ByWindow {
 	stats := ByWindow{}
 	for _, windowDur := range windows {
-		window := Window{windowDur}
-		aggr, ok := a[window]
-		if !ok {
-			aggr = &Aggregator{}
-			a[window] = aggr
-		}
-		if measure != nil {
-			aggr.Add(ts, *measure)
+		stats[Window{windowDur}] = a.update(windowDur, ts, measure).Average()
+	}
+	return stats
+}
+
+func (a WindowAggregators) Summaries(windows []time.Duration, ts time.Time, measure *float64) SummaryByWindow {
+	stats := SummaryByWindow{}
+	for _, windowDur := range windows {
+		aggr := a.update(windowDur, ts, measure)
+		stats[Window{windowDur}] = Summary{
+			Min: aggr.Min(),
+			Max: aggr.Max(),
+			Avg: aggr.Average(),
+			P95: aggr.Percentile(95),
 		}
-		stats[window] = aggr.Clip(windowDur).Average()
 	}
 	return stats
 }
+
+// Compute adds the measure, if any, and returns the values of the given stats
+// for each of the windows.
+func (a WindowAggregators) Compute(windows []time.Duration, ts time.Time, measure *float64, stats []Stat) ValuesByWindow {
+	result := ValuesByWindow{}
+	for _, windowDur := range windows {
+		aggr := a.update(windowDur, ts, measure)
+		values := make(Values, len(stats))
+		for _, stat := range stats {
+			values[stat] = aggr.Compute(stat)
+		}
+		result[Window{windowDur}] = values
+	}
+	return result
+}
+
+func (a WindowAggregators) update(windowDur time.Duration, ts time.Time, measure *float64) *Aggregator {
+	window := Window{windowDur}
+	aggr, ok := a[window]
+	if !ok {
+		aggr = &Aggregator{}
+		a[window] = aggr
+	}
+	if measure != nil {
+		aggr.Add(ts, *measure)
+	}
+	return aggr.Clip(windowDur)
+}